@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"skogkursbachelor/server/internal/http/server"
+	"skogkursbachelor/server/internal/http/upstream"
+	"skogkursbachelor/server/internal/models"
+	"skogkursbachelor/server/internal/services/enrichment"
+	"skogkursbachelor/server/internal/services/roadstore"
+	"skogkursbachelor/server/internal/services/senorge"
+	"skogkursbachelor/server/internal/services/superficialdeposits"
+	"skogkursbachelor/server/internal/services/trafficability"
+	"skogkursbachelor/server/internal/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// serve starts the HTTP server.
+func serve(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("serve takes no arguments")
+	}
+
+	server.Start()
+	return nil
+}
+
+// queryPoint prints the superficial deposits at a coordinate as JSON.
+func queryPoint(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: query-point <x> <y>, in EPSG:25833")
+	}
+
+	x, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return fmt.Errorf("invalid x: %s", args[0])
+	}
+	y, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return fmt.Errorf("invalid y: %s", args[1])
+	}
+
+	deposits, err := superficialdeposits.QueryPoint(x, y)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(os.Stdout, deposits)
+}
+
+// enrichGeoJSON enriches a file of forestry roads, and writes them as a GeoJSON FeatureCollection.
+func enrichGeoJSON(args []string) error {
+	flags := flag.NewFlagSet("enrich-geojson", flag.ExitOnError)
+	timeDate := flags.String("time", "", "date of the SeNorge data, YYYY-MM-DD")
+	startDate := flags.String("start", "", "first date of a SeNorge date range, YYYY-MM-DD")
+	endDate := flags.String("end", "", "last date of a SeNorge date range, YYYY-MM-DD")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		return fmt.Errorf("usage: enrich-geojson [-time YYYY-MM-DD | -start YYYY-MM-DD -end YYYY-MM-DD] <input> <output>")
+	}
+
+	if *timeDate != "" {
+		*startDate, *endDate = *timeDate, *timeDate
+	}
+	for _, date := range []string{*startDate, *endDate} {
+		if _, err := time.Parse(time.DateOnly, date); date != "" && err != nil {
+			return fmt.Errorf("%s is not a date formatted as YYYY-MM-DD", date)
+		}
+	}
+	if (*startDate == "") != (*endDate == "") || *endDate < *startDate {
+		return fmt.Errorf("give both -start and -end, with -end on or after -start")
+	}
+
+	roads, err := roadstore.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	err = server.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	// Stop on Ctrl+C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	response := models.WFSResponse{Type: "FeatureCollection", Features: roads}
+	featureMap := response.ClusterWFSResponseToShardedMap().GetFeaturesFromShardedMap()
+
+	warnings := enrichment.Enrich(ctx, &featureMap, *startDate, *endDate)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	for _, warning := range warnings {
+		log.Warn().Msgf("%s: %s", warning.Source, warning.Message)
+	}
+
+	response.Features = make([]models.ForestRoad, 0, len(roads))
+	for _, features := range featureMap {
+		response.Features = append(response.Features, features...)
+	}
+	response.NumberMatched = len(response.Features)
+	response.Date = time.Now().Format(time.RFC3339)
+	response.Crs.Type = "name"
+	response.Crs.Properties.Name = "urn:ogc:def:crs:EPSG::25833"
+	response.Warnings = warnings
+	if *startDate != "" {
+		response.Enheter = senorge.Units()
+	}
+
+	output := os.Stdout
+	if flags.Arg(1) != "-" {
+		output, err = os.Create(flags.Arg(1))
+		if err != nil {
+			return err
+		}
+		defer output.Close()
+	}
+
+	err = json.NewEncoder(output).Encode(response)
+	if err != nil {
+		return fmt.Errorf("failed to write roads: %v", err)
+	}
+
+	log.Info().Msgf("Enriched %d roads", len(response.Features))
+	return nil
+}
+
+// buildIndex reads the shapefiles into the spatial indexes, and reports their size.
+func buildIndex(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("build-index takes no arguments")
+	}
+
+	started := time.Now()
+	deposits, fjords := superficialdeposits.IndexSizes()
+	log.Info().Msgf("Built spatial indexes in %s", time.Since(started).Round(time.Millisecond))
+
+	fmt.Printf("superficial deposit polygons: %d\nfjord polygons: %d\n", deposits, fjords)
+
+	if deposits == 0 {
+		return fmt.Errorf("no superficial deposit polygons were read, see data/Losmasse/README.md")
+	}
+	return nil
+}
+
+// checkConfig checks every config file, the shapefiles and the stores, and reports each of them.
+func checkConfig(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("check-config takes no arguments")
+	}
+
+	checks := []struct {
+		name  string
+		check func() error
+	}{
+		{"proxy.json", checkProxies},
+		{"upstreams.json", upstream.LoadConfigFromFile},
+		{"senorge.json", senorge.LoadThemesFromFile},
+		{"trafficability.json", trafficability.LoadRulesFromFile},
+		{"superficial deposit files", superficialdeposits.CheckFiles},
+		{"SeNorge store", func() error { return senorge.CheckStore(os.Getenv("SENORGE_DATA_DIR")) }},
+		{"precomputed deposits", func() error { return superficialdeposits.InitStore(os.Getenv("DEPOSITS_DATA_DIR")) }},
+		{"forestry road store", checkRoadStore},
+	}
+
+	failed := 0
+	for _, check := range checks {
+		err := check.check()
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %s\n", check.name, strings.ReplaceAll(err.Error(), "\n", "; "))
+			continue
+		}
+		fmt.Printf("ok   %s\n", check.name)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+// checkProxies checks that every proxy has an absolute http or https address.
+func checkProxies() error {
+	proxies, err := utils.LoadProxiesFromFile()
+	if err != nil {
+		return err
+	}
+
+	for path, remoteAddr := range proxies {
+		remoteURL, err := url.Parse(remoteAddr)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if (remoteURL.Scheme != "http" && remoteURL.Scheme != "https") || remoteURL.Host == "" {
+			return fmt.Errorf("%s: %s is not an absolute http or https address", path, remoteAddr)
+		}
+	}
+
+	return nil
+}
+
+// checkRoadStore opens the forestry road store, if the server is configured to serve roads from it.
+func checkRoadStore() error {
+	if os.Getenv("FORESTRY_ROADS_SOURCE") != "local" {
+		return nil
+	}
+
+	err := roadstore.InitStore(os.Getenv("FORESTRY_ROADS_DATA_DIR"))
+	if err != nil {
+		return err
+	}
+
+	info, err := roadstore.GetInfo()
+	if err != nil {
+		return err
+	}
+	if info.Roads == 0 {
+		return fmt.Errorf("the store has no roads")
+	}
+	return nil
+}
+
+// importRoads imports a forestry road dataset into the local road store.
+func importRoads(args []string) error {
+	flags := flag.NewFlagSet("import-roads", flag.ExitOnError)
+	dataDir := flags.String("dir", os.Getenv("FORESTRY_ROADS_DATA_DIR"), "data directory of the road store")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: import-roads [-dir <data directory>] <GeoJSON, Shapefile or GML file>")
+	}
+
+	// Stop the import on Ctrl+C, without leaving a half written store
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	count, err := roadstore.Import(ctx, flags.Arg(0), *dataDir)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Imported %d forestry roads into %s", count, *dataDir)
+	return nil
+}
+
+// precomputeDeposits looks up the superficial deposits of the roads in a bbox or county, and stores them.
+// The roads are read from a dataset file if one is given, otherwise from the local road store.
+func precomputeDeposits(args []string) error {
+	flags := flag.NewFlagSet("precompute-deposits", flag.ExitOnError)
+	dataDir := flags.String("dir", os.Getenv("DEPOSITS_DATA_DIR"), "data directory of the deposit store")
+	roadsDir := flags.String("roads", os.Getenv("FORESTRY_ROADS_DATA_DIR"), "data directory of the road store")
+	bboxFlag := flags.String("bbox", "", "only roads in minx,miny,maxx,maxy, in EPSG:25833")
+	county := flags.String("county", "", "only roads in the county, by fylkesnummer, ex: 34")
+	_ = flags.Parse(args)
+
+	if flags.NArg() > 1 || *dataDir == "" {
+		return fmt.Errorf("usage: precompute-deposits [-dir <data directory>] [-bbox minx,miny,maxx,maxy] [-county <fylkesnummer>] [file]")
+	}
+
+	var bbox []float64
+	if *bboxFlag != "" {
+		parts := strings.Split(*bboxFlag, ",")
+		if len(parts) != 4 {
+			return fmt.Errorf("invalid bbox, expected minx,miny,maxx,maxy: %s", *bboxFlag)
+		}
+		for _, part := range parts {
+			number, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil {
+				return fmt.Errorf("invalid bbox, expected minx,miny,maxx,maxy: %s", *bboxFlag)
+			}
+			bbox = append(bbox, number)
+		}
+	}
+
+	var roads []models.ForestRoad
+	var err error
+	if flags.NArg() == 1 {
+		roads, err = roadstore.ReadFile(flags.Arg(0))
+	} else {
+		err = roadstore.InitStore(*roadsDir)
+		if err == nil {
+			roads, err = roadstore.All()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read forestry roads: %v", err)
+	}
+
+	roads = roadstore.Filter(roads, bbox, *county)
+	log.Info().Msgf("Precomputing superficial deposits for %d roads...", len(roads))
+
+	// Stop on Ctrl+C, the roads done so far are kept
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	count, err := superficialdeposits.Precompute(ctx, roads, *dataDir)
+	if err != nil {
+		return fmt.Errorf("failed after %d roads: %v", count, err)
+	}
+
+	log.Info().Msgf("Precomputed superficial deposits for %d roads into %s", count, *dataDir)
+	return nil
+}
+
+func printJSON(output *os.File, value interface{}) error {
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
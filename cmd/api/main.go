@@ -1,16 +1,71 @@
-// Package main is the entry point for the application, it starts the server.
+// Package main is the entry point for the application. Without arguments it starts the server,
+// otherwise it runs the given command, see the usage below.
 package main
 
 import (
+	"fmt"
 	"os"
 	"skogkursbachelor/server/internal/config"
-	"skogkursbachelor/server/internal/http/server"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
-// Start the server
+// command is a subcommand of the binary.
+type command struct {
+	name        string
+	usage       string
+	description string
+	run         func(args []string) error
+}
+
+// _commands are the subcommands, the first one is run when no command is given
+var _commands = []command{
+	{
+		name:        "serve",
+		usage:       "serve",
+		description: "start the HTTP server",
+		run:         serve,
+	},
+	{
+		name:        "query-point",
+		usage:       "query-point <x> <y>",
+		description: "print the superficial deposits at a coordinate in EPSG:25833, and if it is in a fjord",
+		run:         queryPoint,
+	},
+	{
+		name:        "enrich-geojson",
+		usage:       "enrich-geojson [-time YYYY-MM-DD | -start YYYY-MM-DD -end YYYY-MM-DD] <input> <output>",
+		description: "enrich a file of forestry roads, output - writes to stdout. Without dates, SeNorge is skipped and no network is used",
+		run:         enrichGeoJSON,
+	},
+	{
+		name:        "build-index",
+		usage:       "build-index",
+		description: "read the superficial deposit and fjord shapefiles, and report the size of the spatial indexes",
+		run:         buildIndex,
+	},
+	{
+		name:        "check-config",
+		usage:       "check-config",
+		description: "check the config files, shapefiles and stores without starting the server",
+		run:         checkConfig,
+	},
+	{
+		name:        "import-roads",
+		usage:       "import-roads [-dir <data directory>] <GeoJSON, Shapefile or GML file>",
+		description: "import a forestry road dataset into the local road store",
+		run:         importRoads,
+	},
+	{
+		name:        "precompute-deposits",
+		usage:       "precompute-deposits [-dir <data directory>] [-bbox minx,miny,maxx,maxy] [-county <fylkesnummer>] [file]",
+		description: "precompute superficial deposits for the roads in a file, or in the local road store",
+		run:         precomputeDeposits,
+	},
+}
+
+// Run the command given as the first argument, or start the server
 func main() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 
@@ -31,5 +86,33 @@ func main() {
 
 	log.Info().Msg("Configuration loaded successfully")
 
-	server.Start()
+	name := _commands[0].name
+	var args []string
+	if len(os.Args) > 1 {
+		name, args = os.Args[1], os.Args[2:]
+	}
+
+	for _, cmd := range _commands {
+		if cmd.name != name {
+			continue
+		}
+
+		err = cmd.run(args)
+		if err != nil {
+			log.Fatal().Msgf("Error running %s: %s", name, err)
+		}
+		return
+	}
+
+	printUsage()
+	if name != "help" && name != "-h" && name != "--help" {
+		os.Exit(2)
+	}
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [arguments]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range _commands {
+		fmt.Fprintf(os.Stderr, "  %s\n      %s\n", cmd.usage, cmd.description)
+	}
 }
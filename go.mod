@@ -10,6 +10,7 @@ require (
 	github.com/tidwall/rtree v1.10.0
 	github.com/twpayne/go-geom v1.6.0
 	github.com/twpayne/go-shapefile v0.0.5
+	go.etcd.io/bbolt v1.4.0
 )
 
 require (
@@ -10,6 +10,10 @@ const Version = "v1"
 
 const APIPath = DefaultPath + "api/" + Version + "/"
 const ForestryRoadsPath = APIPath + "forestryroads"
+const ForestryRoadTilesPath = ForestryRoadsPath + "/tiles"
+const WMSPath = APIPath + "wms"
+const ConformancePath = APIPath + "conformance"
+const CollectionsPath = APIPath + "collections"
 
 const ProxyPath = DefaultPath + "proxy/"
 const ForestLegendPath = ProxyPath + "legend/forestryroads"
@@ -24,3 +28,5 @@ const ForestryRoadsWFS = "https://wms.geonorge.no/skwms1/wms.traktorveg_skogsbil
 
 const SeNorgeFrostDepthTheme = "gwb_frd"
 const SeNorgeWaterSaturationTheme = "gwb_sssrel"
+const SeNorgeSnowDepthTheme = "sd"
+const SeNorgeSnowWaterEquivalentTheme = "swe"
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"skogkursbachelor/server/internal/http/upstream"
 	"strconv"
 
 	"github.com/rs/zerolog/log"
@@ -67,7 +68,7 @@ func handleBaseLayerGet(w http.ResponseWriter, r *http.Request) {
 	}
 	url += ".png"
 
-	proxyReq, err := http.NewRequest(r.Method, url, nil)
+	proxyReq, err := http.NewRequestWithContext(r.Context(), r.Method, url, nil)
 	if err != nil {
 		log.Error().Msg("Error creating request: " + err.Error())
 		http.Error(w, "Failed to create request", http.StatusInternalServerError)
@@ -82,7 +83,7 @@ func handleBaseLayerGet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Make the request
-	resp, err := http.DefaultClient.Do(proxyReq)
+	resp, err := upstream.Do(proxyReq)
 	if err != nil {
 		log.Error().Msg("Error making request: " + err.Error())
 		http.Error(w, "Failed to fetch data from WMS server", http.StatusBadGateway)
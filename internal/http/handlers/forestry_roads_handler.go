@@ -4,21 +4,30 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"skogkursbachelor/server/internal/constants"
 	"skogkursbachelor/server/internal/models"
+	"skogkursbachelor/server/internal/services/forestryroads"
 	"skogkursbachelor/server/internal/services/senorge"
-	"skogkursbachelor/server/internal/services/superficialdeposits"
+	"sort"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
 // _implementedMethods is a list of the implemented HTTP methods for the status endpoint.
-var _implementedMethods = []string{http.MethodGet}
+var _implementedMethods = []string{http.MethodGet, http.MethodPost}
+
+// _maxDateRangeDays is the longest date range that can be requested, SeNorge returns one value per day
+const _maxDateRangeDays = 366
+
+// _maxForecastDays is how many days after today that can be requested, values after today are SeNorge forecasts
+const _maxForecastDays = 9
+
+// _maxBBoxSize is the widest and tallest bbox, in meters, roads can be requested for, from the WFS or the store
+const _maxBBoxSize = 100000
 
 // ForestryRoadsHandler handles requests to the forestry road endpoint.
-// Currently only GET requests are supported.
+// GET enriches the roads in the WFS, POST enriches posted roads.
 func ForestryRoadsHandler(w http.ResponseWriter, r *http.Request) {
 	// Enable CORS
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -29,6 +38,15 @@ func ForestryRoadsHandler(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		handleForestryRoadGet(w, r)
 
+	case http.MethodPost:
+		handleForestryRoadPost(w, r)
+
+	case http.MethodOptions:
+		// CORS preflight, browsers send it before posting JSON
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(_implementedMethods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+
 	default:
 		// If the method is not implemented, return an error with the allowed methods
 		http.Error(
@@ -41,123 +59,150 @@ func ForestryRoadsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleForestryRoadGet handles GET requests to the forestry road endpoint.
+// handleForestryRoadGet handles GET requests to the forestry road endpoint. The URL parameters are checked,
+// and the roads in the bbox are read from the local store if there is one, otherwise from the GeoNorge WFS.
+// A feature type given by typeNames must be the feature type of the forestry roads in the WFS.
+// Roads are ordered by id, and paged by count and startIndex before they are enriched.
+// Roads are returned in the CRS of the crs or srsName parameter, EPSG:25833 by default. The bbox and the
+// coordinates of the roads are in the axis order of the CRS, latitude first in EPSG:4326.
 func handleForestryRoadGet(w http.ResponseWriter, r *http.Request) {
-	// Get timeDate parameter from url
-	timeDate := r.URL.Query().Get("time")
-	if timeDate == "" {
-		log.Warn().Str("request", r.URL.String()).Msg("Missing time URL parameter")
-		http.Error(w, "Missing time URL parameter", http.StatusBadRequest)
+	query, invalid := parseForestryRoadsQuery(r)
+	if len(invalid) > 0 {
+		writeQueryError(w, r, invalid)
 		return
 	}
 
-	// Split ISO string to get date. ex: 2021-03-01T00:00:00Z -> 2021-03-01
-	// Gets put into struct later
-	date := strings.Split(timeDate, "T")[0]
-	if date == "" {
-		log.Warn().Str("request", r.URL.String()).Msg("Failed to split time string")
-		http.Error(w, "Failed to split time string", http.StatusBadRequest)
+	invalid, err := checkTypeName(r.Context(), query.typeName)
+	if !writeForestryRoadsError(w, r, err) {
 		return
 	}
-
-	// Mirror request to https://wms.geonorge.no/skwms1/wms.traktorveg_skogsbilveger
-	proxyReq, err := http.NewRequest(
-		r.Method,
-		constants.ForestryRoadsWFS+"?"+r.URL.RawQuery,
-		r.Body,
-	)
-	if err != nil {
-		http.Error(w, "Failed to create internal request", http.StatusInternalServerError)
-		log.Error().Msg("Error creating request to GeoNorge for forestry roads: " + err.Error())
+	if len(invalid) > 0 {
+		writeQueryError(w, r, invalid)
 		return
 	}
 
-	// Do request
-	proxyResp, err := http.DefaultClient.Do(proxyReq)
-	if err != nil {
-		http.Error(w, "Failed to fetch data from external WMS server", http.StatusBadGateway)
-		log.Error().Msg("Error fetching data from GeoNorge WMS server: " + err.Error())
+	response, err := forestryroads.GetRoads(r.Context(), query.minX, query.minY, query.maxX, query.maxY)
+	if !writeForestryRoadsError(w, r, err) {
 		return
 	}
 
-	// Decode into struct
-	var wfsResponse models.WFSResponse
-	err = json.NewDecoder(proxyResp.Body).Decode(&wfsResponse)
-	if err != nil {
-		http.Error(w, "Failed to decode external response", http.StatusInternalServerError)
-		log.Error().Msg("Error decoding response from GeoNorge WMS server: " + err.Error())
-		return
+	roads := response.Features
+	forestryroads.SetFeatureIDs(roads)
+	sort.Slice(roads, func(i, j int) bool { return roads[i].ID < roads[j].ID })
+
+	start := min(query.startIndex, len(roads))
+	end := len(roads)
+	if query.count > 0 {
+		end = start + min(query.count, len(roads)-start)
 	}
+	page := append([]models.ForestRoad{}, roads[start:end]...)
+
+	response.NumberMatched = len(roads)
+	response.Features = page
+	response.Enheter = senorge.Units()
 
-	// If there are 0 roads, just return the wfsResponse
-	if wfsResponse.NumberMatched == 0 {
-		log.Debug().Str("request", r.URL.String()).Msg("No features found in WFS response")
-		err = json.NewEncoder(w).Encode(wfsResponse)
+	// Failed enrichments are left as null, and described in the warnings of the response
+	if len(page) > 0 {
+		response.Warnings, err = forestryroads.Enrich(r.Context(), page, query.startDate, query.endDate)
 		if err != nil {
-			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-			log.Error().Msg("Error encoding final response: " + err.Error())
+			log.Debug().Str("request", r.URL.String()).Msg("Request abandoned during enrichment")
 			return
 		}
-		return
 	}
+	forestryroads.Reproject(&response, query.crs)
 
-	// Group the features by EPSG25833 coordinates, each with a cluster at coordinates: xxx500, yyy500
-	// This is a center point of a 1000x1000 meter square, and the center of SeNorge grid cells
-
-	shardedMap := wfsResponse.ClusterWFSResponseToShardedMap()
-	featureMap := shardedMap.GetFeaturesFromShardedMap()
-
-	// Superficial depositz
-	err = superficialdeposits.UpdateSuperficialDepositCodes(&featureMap)
+	// Encode response
+	err = json.NewEncoder(w).Encode(response)
 	if err != nil {
-		http.Error(w, "Failed to update superficial deposit data", http.StatusInternalServerError)
-		log.Error().Msg("Error updating superficial deposit data: " + err.Error())
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		log.Error().Msg("Error encoding final response: " + err.Error())
 		return
 	}
+}
 
-	var wg sync.WaitGroup
-	wg.Add(2)
+// getDateRange returns the start and end date of the request, formatted as YYYY-MM-DD.
+// A date range is given by the start and end URL parameters, otherwise the time parameter is used for both.
+func getDateRange(r *http.Request) (string, string, error) {
+	query := r.URL.Query()
 
-	var err1 error
-	go func() {
-		defer wg.Done()
-		err1 = senorge.UpdateFrostDepth(&featureMap, date)
-	}()
+	if !query.Has("start") && !query.Has("end") {
+		// Get timeDate parameter from url
+		timeDate := query.Get("time")
+		if timeDate == "" {
+			return "", "", fmt.Errorf("missing time URL parameter")
+		}
 
-	var err2 error
-	go func() {
-		defer wg.Done()
-		err2 = senorge.UpdateWaterSaturation(&featureMap, date)
-	}()
+		date, err := parseDate(timeDate)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid time URL parameter: %v", err)
+		}
 
-	wg.Wait()
+		err = checkForecastLimit(date)
+		if err != nil {
+			return "", "", err
+		}
 
-	if err1 != nil {
-		log.Error().Msg("Error getting frozen status: " + err1.Error())
+		return date, date, nil
 	}
-	if err2 != nil {
-		log.Error().Msg("Error getting waterSaturation: " + err2.Error())
+
+	startDate, err := parseDate(query.Get("start"))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid start URL parameter: %v", err)
 	}
-	if err1 != nil || err2 != nil {
-		http.Error(w, "Error getting external data", http.StatusInternalServerError)
-		return
+
+	endDate, err := parseDate(query.Get("end"))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid end URL parameter: %v", err)
 	}
 
-	transcribedFeatures := make([]models.ForestRoad, 0, len(wfsResponse.Features))
+	err = checkDateRange(startDate, endDate)
+	if err != nil {
+		return "", "", err
+	}
 
-	// Iterate over the featuremap and update the features with the frost data
-	for _, features := range featureMap {
-		transcribedFeatures = append(transcribedFeatures, features...)
+	return startDate, endDate, nil
+}
+
+// checkDateRange checks that the end date is not before the start date, that the range is not too long,
+// and that the end date is not further ahead than SeNorge forecasts. The dates are formatted as YYYY-MM-DD.
+func checkDateRange(startDate, endDate string) error {
+	start, _ := time.Parse(time.DateOnly, startDate)
+	end, _ := time.Parse(time.DateOnly, endDate)
+	if end.Before(start) {
+		return fmt.Errorf("end date %s is before start date %s", endDate, startDate)
+	}
+	if end.Sub(start) > _maxDateRangeDays*24*time.Hour {
+		return fmt.Errorf("date range is longer than %d days", _maxDateRangeDays)
 	}
 
-	// Replace the features with the transcribed features
-	wfsResponse.Features = transcribedFeatures
+	return checkForecastLimit(endDate)
+}
 
-	// Encode response
-	err = json.NewEncoder(w).Encode(wfsResponse)
-	if err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-		log.Error().Msg("Error encoding final response: " + err.Error())
-		return
+// parseDate returns the date of an ISO 8601 date or timestamp, and checks that both the date and time are valid.
+// ex: 2021-03-01T00:00:00Z -> 2021-03-01
+func parseDate(timeDate string) (string, error) {
+	if timeDate == "" {
+		return "", fmt.Errorf("missing date")
+	}
+
+	for _, layout := range []string{time.DateOnly, time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		parsed, err := time.Parse(layout, timeDate)
+		if err == nil {
+			return parsed.Format(time.DateOnly), nil
+		}
 	}
+
+	return "", fmt.Errorf("%s is not an ISO 8601 date formatted as YYYY-MM-DD or YYYY-MM-DDTHH:MM:SSZ", timeDate)
+}
+
+// checkForecastLimit checks that the date is not further ahead than SeNorge forecasts.
+func checkForecastLimit(date string) error {
+	lastForecastDate := time.Now().AddDate(0, 0, _maxForecastDays).Format(time.DateOnly)
+
+	// Dates formatted as YYYY-MM-DD sort lexically
+	if date > lastForecastDate {
+		return fmt.Errorf("date %s is more than %d days ahead, the last forecast date is %s", date, _maxForecastDays, lastForecastDate)
+	}
+
+	return nil
 }
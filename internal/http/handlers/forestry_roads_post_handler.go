@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"skogkursbachelor/server/internal/models"
+	"skogkursbachelor/server/internal/projection"
+	"skogkursbachelor/server/internal/services/forestryroads"
+	"skogkursbachelor/server/internal/services/roadstore"
+	"skogkursbachelor/server/internal/services/senorge"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// _maxPostBodyBytes is the largest GeoJSON document that can be posted
+const _maxPostBodyBytes = 10 << 20
+
+// _maxPostFeatures is the most roads that can be posted in one request
+const _maxPostFeatures = 10000
+
+// roadCollection is a posted GeoJSON FeatureCollection of roads.
+type roadCollection struct {
+	Type string `json:"type"`
+	Crs  struct {
+		Properties struct {
+			Name string `json:"name"`
+		} `json:"properties"`
+	} `json:"crs"`
+	Features []struct {
+		Type       string                 `json:"type"`
+		Properties map[string]interface{} `json:"properties"`
+		Geometry   struct {
+			Type        string      `json:"type"`
+			Coordinates [][]float64 `json:"coordinates"`
+		} `json:"geometry"`
+	} `json:"features"`
+}
+
+// handleForestryRoadPost handles POST requests to the forestry road endpoint. The body is a GeoJSON
+// FeatureCollection of LineStrings, ex: planned roads that are not in the WFS yet. The CRS of the roads is
+// given by the crs URL parameter or the crs member of the body, and is EPSG:25833 by default. Coordinates are in
+// the axis order of the CRS, latitude first in EPSG:4326.
+// The roads are enriched like the roads from the WFS, and returned in their CRS, in the order they were posted.
+// Properties that are not forestry road properties are kept as they are.
+func handleForestryRoadPost(w http.ResponseWriter, r *http.Request) {
+	// Get the dates from the url, either a single time or a start and end date
+	startDate, endDate, err := getDateRange(r)
+	if err != nil {
+		log.Warn().Str("request", r.URL.String()).Msg(err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var crs projection.CRS
+	if r.URL.Query().Has("crs") {
+		crs, err = projection.ParseCRS(r.URL.Query().Get("crs"))
+		if err != nil {
+			log.Warn().Str("request", r.URL.String()).Msg(err.Error())
+			http.Error(w, "invalid crs URL parameter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	roads, crs, err := decodeRoadCollection(http.MaxBytesReader(w, r.Body, _maxPostBodyBytes), crs)
+	if err != nil {
+		log.Warn().Str("request", r.URL.String()).Msg(err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Failed enrichments are left as null, and described in the warnings of the response
+	warnings, err := forestryroads.Enrich(r.Context(), roads, startDate, endDate)
+	if err != nil {
+		log.Debug().Str("request", r.URL.String()).Msg("Request abandoned during enrichment")
+		return
+	}
+
+	response := models.WFSResponse{
+		Type:          "FeatureCollection",
+		NumberMatched: len(roads),
+		Name:          "forestryroads",
+		Date:          time.Now().Format(time.RFC3339),
+		Enheter:       senorge.Units(),
+		Warnings:      warnings,
+		Features:      roads,
+	}
+	forestryroads.Reproject(&response, crs)
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		log.Error().Msg("Error encoding final response: " + err.Error())
+		return
+	}
+}
+
+// decodeRoadCollection decodes and checks a posted FeatureCollection, and converts its features to roads
+// in EPSG:25833. The CRS of the roads is the crs member of the body, which must match the CRS given if any,
+// and is returned. Without either, the roads are in EPSG:25833.
+func decodeRoadCollection(body io.Reader, crs projection.CRS) ([]models.ForestRoad, projection.CRS, error) {
+	var collection roadCollection
+	err := json.NewDecoder(body).Decode(&collection)
+	if err != nil {
+		return nil, crs, fmt.Errorf("body is not a GeoJSON FeatureCollection of LineStrings: %v", err)
+	}
+
+	if collection.Type != "FeatureCollection" {
+		return nil, crs, fmt.Errorf("body must be a FeatureCollection, got %q", collection.Type)
+	}
+
+	if name := collection.Crs.Properties.Name; name != "" {
+		bodyCRS, err := projection.ParseCRS(name)
+		if err != nil {
+			return nil, crs, err
+		}
+		if crs.Code != "" && bodyCRS.Code != crs.Code {
+			return nil, crs, fmt.Errorf("the CRS of the body, %s, is not the crs URL parameter %s", bodyCRS.Code, crs.Code)
+		}
+		crs = bodyCRS
+	}
+	if crs.Code == "" {
+		crs, _ = projection.ParseCRS(projection.Internal)
+	}
+
+	if len(collection.Features) == 0 {
+		return nil, crs, fmt.Errorf("the FeatureCollection has no features")
+	}
+	if len(collection.Features) > _maxPostFeatures {
+		return nil, crs, fmt.Errorf("the FeatureCollection has %d features, the most is %d", len(collection.Features), _maxPostFeatures)
+	}
+
+	roads := make([]models.ForestRoad, len(collection.Features))
+	for i, feature := range collection.Features {
+		if feature.Geometry.Type != "LineString" {
+			return nil, crs, fmt.Errorf("feature %d: geometry must be a LineString, got %q", i, feature.Geometry.Type)
+		}
+
+		coordinates := feature.Geometry.Coordinates
+		if len(coordinates) < 2 {
+			return nil, crs, fmt.Errorf("feature %d: a LineString needs at least 2 coordinates", i)
+		}
+		for _, coordinate := range coordinates {
+			if len(coordinate) < 2 {
+				return nil, crs, fmt.Errorf("feature %d: coordinates need an x and a y", i)
+			}
+		}
+
+		// Coordinates are in the axis order of the CRS, latitude first in EPSG:4326
+		xIndex, yIndex := 0, 1
+		if crs.LatLon {
+			xIndex, yIndex = 1, 0
+		}
+
+		// Coordinates in degrees in a projected CRS, or the other way around, are roads in the wrong CRS
+		inDegrees := math.Abs(coordinates[0][xIndex]) <= 180 && math.Abs(coordinates[0][yIndex]) <= 90
+		if inDegrees && !crs.Geographic {
+			return nil, crs, fmt.Errorf("feature %d: coordinates look like degrees, roads must be in %s", i, crs.Code)
+		}
+		if !inDegrees && crs.Geographic {
+			return nil, crs, fmt.Errorf("feature %d: coordinates must be longitude and latitude in degrees in %s", i, crs.Code)
+		}
+
+		if !crs.IsInternal() {
+			internal := make([][]float64, len(coordinates))
+			for j, coordinate := range coordinates {
+				x, y := crs.ToInternal(coordinate[xIndex], coordinate[yIndex])
+				internal[j] = []float64{x, y}
+			}
+			coordinates = internal
+		}
+
+		road := models.ForestRoad{Type: "Feature"}
+		road.Geometry.Type = "LineString"
+		road.Geometry.Coordinates = coordinates
+
+		for key, value := range feature.Properties {
+			if roadstore.SetProperty(&road.Properties, key, value) || models.IsForestRoadProperty(key) {
+				continue
+			}
+			road.Properties.SetEkstra(key, value)
+		}
+
+		roads[i] = road
+	}
+
+	return roads, crs, nil
+}
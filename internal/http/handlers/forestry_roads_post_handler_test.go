@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"math"
+	"skogkursbachelor/server/internal/projection"
+	"strings"
+	"testing"
+)
+
+func TestDecodeRoadCollectionAxisOrder(t *testing.T) {
+	// The same road in Oslo, latitude first in EPSG:4326 and longitude first in CRS84
+	tests := []struct {
+		crs         string
+		coordinates string
+	}{
+		{crs: "EPSG:25833", coordinates: "[[262409.732,6649017.749],[262509.732,6649117.749]]"},
+		{crs: "urn:ogc:def:crs:EPSG::4326", coordinates: "[[59.91,10.75],[59.911,10.752]]"},
+		{crs: "urn:ogc:def:crs:OGC:1.3:CRS84", coordinates: "[[10.75,59.91],[10.752,59.911]]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.crs, func(t *testing.T) {
+			body := `{"type":"FeatureCollection","crs":{"type":"name","properties":{"name":"` + tt.crs + `"}},` +
+				`"features":[{"type":"Feature","properties":{},"geometry":{"type":"LineString","coordinates":` + tt.coordinates + `}}]}`
+
+			roads, _, err := decodeRoadCollection(strings.NewReader(body), projection.CRS{})
+			if err != nil {
+				t.Fatalf("decodeRoadCollection returned %v", err)
+			}
+
+			got := roads[0].Geometry.Coordinates[0]
+			if math.Abs(got[0]-262409.732) > 1 || math.Abs(got[1]-6649017.749) > 1 {
+				t.Errorf("decodeRoadCollection(%s) first coordinate = %v, want Oslo", tt.crs, got)
+			}
+		})
+	}
+}
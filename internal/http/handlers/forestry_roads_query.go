@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"skogkursbachelor/server/internal/models"
+	"skogkursbachelor/server/internal/projection"
+	"skogkursbachelor/server/internal/services/forestryroads"
+	"skogkursbachelor/server/internal/services/roadstore"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// _forestryRoadsParameters are the URL parameters of a GET request to the forestry road endpoint, lowercase.
+// The WFS parameters are accepted so WFS clients keep working, but the query sent to the WFS is built by the server.
+var _forestryRoadsParameters = []string{
+	"service", "version", "request", "typename", "typenames", "outputformat", "srsname",
+	"bbox", "count", "maxfeatures", "startindex", "time", "start", "end", "crs",
+}
+
+// _wfsVersions are the WFS versions clients may give
+var _wfsVersions = []string{"1.0.0", "1.1.0", "2.0.0", "2.0.2"}
+
+// forestryRoadsQuery is the checked query of a GET request to the forestry road endpoint.
+type forestryRoadsQuery struct {
+	// bbox in EPSG:25833
+	minX, minY, maxX, maxY float64
+	// crs is the CRS of the returned roads, and of the bbox if it has no CRS
+	crs projection.CRS
+	// typeName is the WFS feature type the client asked for, empty if not given. It is checked against the
+	// feature type of the WFS before roads are fetched, see checkTypeName
+	typeName models.InvalidParameter
+	// startDate and endDate are formatted as YYYY-MM-DD, and are the same for a single date
+	startDate, endDate string
+	// count is the most roads to return, 0 returns every road
+	count int
+	// startIndex is the index of the first road to return, of the roads ordered by id
+	startIndex int
+}
+
+// queryParameters holds the URL parameters by lowercase name, and collects the invalid ones.
+type queryParameters struct {
+	values   map[string]string
+	names    map[string]string
+	repeated map[string]bool
+	invalid  []models.InvalidParameter
+}
+
+// parseForestryRoadsQuery reads and checks every URL parameter of a GET request to the forestry road endpoint.
+// Parameter names are case insensitive, as in WFS. Returns every invalid parameter.
+func parseForestryRoadsQuery(r *http.Request) (forestryRoadsQuery, []models.InvalidParameter) {
+	params := &queryParameters{
+		values:   make(map[string]string),
+		names:    make(map[string]string),
+		repeated: make(map[string]bool),
+	}
+	for key, values := range r.URL.Query() {
+		name := strings.ToLower(key)
+		switch {
+		case !slices.Contains(_forestryRoadsParameters, name):
+			params.addInvalid(key, values[0], "unknown parameter")
+		case len(values) > 1 || params.names[name] != "":
+			params.addInvalid(key, values[0], "given more than once")
+			params.repeated[name] = true
+		default:
+			params.values[name] = values[0]
+			params.names[name] = key
+		}
+	}
+
+	var query forestryRoadsQuery
+
+	// The WFS request, only GetFeature as GeoJSON is answered
+	if service, ok := params.get("service"); ok && !strings.EqualFold(service, "WFS") {
+		params.invalid = append(params.invalid, params.invalidParameter("service", "must be WFS"))
+	}
+	if request, ok := params.get("request"); ok && !strings.EqualFold(request, "GetFeature") {
+		params.invalid = append(params.invalid, params.invalidParameter("request", "must be GetFeature"))
+	}
+	if version, ok := params.get("version"); ok && !slices.Contains(_wfsVersions, version) {
+		params.invalid = append(params.invalid, params.invalidParameter("version", "must be one of "+strings.Join(_wfsVersions, ", ")))
+	}
+	if outputFormat, ok := params.get("outputformat"); ok && !strings.Contains(strings.ToLower(outputFormat), "json") {
+		params.invalid = append(params.invalid, params.invalidParameter("outputformat", "only GeoJSON is supported"))
+	}
+	query.typeName = params.typeName()
+	query.crs = params.outputCRS()
+	query.minX, query.minY, query.maxX, query.maxY = params.bbox("bbox", query.crs)
+	query.startDate, query.endDate = params.dateRange()
+	query.count = params.integer("count", 0, 1)
+	if _, ok := params.get("count"); !ok {
+		query.count = params.integer("maxfeatures", 0, 1)
+	}
+	query.startIndex = params.integer("startindex", 0, 0)
+
+	sort.Slice(params.invalid, func(i, j int) bool { return params.invalid[i].Name < params.invalid[j].Name })
+	return query, params.invalid
+}
+
+// get returns the value of the parameter, and if it is given.
+func (params *queryParameters) get(name string) (string, bool) {
+	value, ok := params.values[name]
+	return value, ok
+}
+
+// addInvalid adds an invalid parameter.
+func (params *queryParameters) addInvalid(name, value, message string) {
+	params.invalid = append(params.invalid, models.InvalidParameter{Name: name, Value: value, Message: message})
+}
+
+// addMissing adds a required parameter that is not given. Parameters given more than once are already invalid.
+func (params *queryParameters) addMissing(name, message string) {
+	if !params.repeated[name] {
+		params.addInvalid(name, "", "missing, "+message)
+	}
+}
+
+// invalidParameter describes a given parameter as invalid, by the name the client used.
+func (params *queryParameters) invalidParameter(name, message string) models.InvalidParameter {
+	return models.InvalidParameter{Name: params.names[name], Value: params.values[name], Message: message}
+}
+
+// typeName returns the typeNames parameter, or typeName as in WFS 1.x, by the name the client used.
+// Only one feature type can be given.
+func (params *queryParameters) typeName() models.InvalidParameter {
+	var typeName models.InvalidParameter
+	for _, name := range []string{"typenames", "typename"} {
+		value, ok := params.get(name)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case strings.Contains(value, ","):
+			params.invalid = append(params.invalid, params.invalidParameter(name, "only one feature type is supported"))
+		case typeName.Name != "" && !sameTypeName(value, typeName.Value):
+			params.invalid = append(params.invalid, params.invalidParameter(name, "is not the feature type of "+typeName.Name))
+		case typeName.Name == "":
+			typeName = models.InvalidParameter{Name: params.names[name], Value: value}
+		}
+	}
+	return typeName
+}
+
+// outputCRS returns the CRS of the crs parameter, or of srsName as in WFS, and EPSG:25833 if neither is given.
+func (params *queryParameters) outputCRS() projection.CRS {
+	crs, _ := projection.ParseCRS(projection.Internal)
+	given := ""
+	for _, name := range []string{"srsname", "crs"} {
+		value, ok := params.get(name)
+		if !ok {
+			continue
+		}
+
+		parsed, err := projection.ParseCRS(value)
+		if err != nil {
+			params.invalid = append(params.invalid, params.invalidParameter(name, err.Error()))
+			continue
+		}
+		if given != "" && parsed.Code != crs.Code {
+			params.invalid = append(params.invalid, params.invalidParameter(name, "is not the CRS of "+params.names[given]))
+			continue
+		}
+		crs, given = parsed, name
+	}
+	return crs
+}
+
+// bbox returns the required bbox parameter, "minx,miny,maxx,maxy" with an optional CRS, transformed to EPSG:25833.
+// Without a CRS in the bbox, it is in the CRS given. Coordinates are in the axis order of the CRS, as in WFS 2.0,
+// ex: "minlat,minlon,maxlat,maxlon" in EPSG:4326.
+func (params *queryParameters) bbox(name string, crs projection.CRS) (float64, float64, float64, float64) {
+	value, ok := params.get(name)
+	if !ok {
+		params.addMissing(name, "expected minx,miny,maxx,maxy in "+crs.Code)
+		return 0, 0, 0, 0
+	}
+
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 && len(parts) != 5 {
+		params.invalid = append(params.invalid, params.invalidParameter(name, "expected minx,miny,maxx,maxy with an optional CRS"))
+		return 0, 0, 0, 0
+	}
+
+	if len(parts) == 5 {
+		var err error
+		crs, err = projection.ParseCRS(parts[4])
+		if err != nil {
+			params.invalid = append(params.invalid, params.invalidParameter(name, err.Error()))
+			return 0, 0, 0, 0
+		}
+	}
+
+	var numbers [4]float64
+	for i := range numbers {
+		number, err := strconv.ParseFloat(strings.TrimSpace(parts[i]), 64)
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+			params.invalid = append(params.invalid, params.invalidParameter(name, fmt.Sprintf("%q is not a number", parts[i])))
+			return 0, 0, 0, 0
+		}
+		numbers[i] = number
+	}
+	if crs.LatLon {
+		numbers = [4]float64{numbers[1], numbers[0], numbers[3], numbers[2]}
+	}
+
+	if numbers[2] < numbers[0] || numbers[3] < numbers[1] {
+		params.invalid = append(params.invalid, params.invalidParameter(name, "min is larger than max"))
+		return 0, 0, 0, 0
+	}
+	if crs.Geographic && (numbers[0] < -180 || numbers[2] > 180 || numbers[1] < -90 || numbers[3] > 90) {
+		params.invalid = append(params.invalid, params.invalidParameter(name, "coordinates must be longitude and latitude in degrees"))
+		return 0, 0, 0, 0
+	}
+
+	minX, minY, maxX, maxY, err := crs.CheckedBoundsToInternal(numbers[0], numbers[1], numbers[2], numbers[3])
+	if err != nil {
+		params.invalid = append(params.invalid, params.invalidParameter(name, err.Error()))
+		return 0, 0, 0, 0
+	}
+	if maxX-minX > _maxBBoxSize || maxY-minY > _maxBBoxSize {
+		params.invalid = append(params.invalid, params.invalidParameter(name, fmt.Sprintf("larger than %d km", _maxBBoxSize/1000)))
+		return 0, 0, 0, 0
+	}
+	return minX, minY, maxX, maxY
+}
+
+// dateRange returns the dates of the request, either a single time or a start and end date.
+func (params *queryParameters) dateRange() (string, string) {
+	_, hasStart := params.get("start")
+	_, hasEnd := params.get("end")
+	if !hasStart && !hasEnd && !params.repeated["start"] && !params.repeated["end"] {
+		value, ok := params.get("time")
+		if !ok {
+			params.addMissing("time", "expected a date formatted as YYYY-MM-DD, or start and end")
+			return "", ""
+		}
+
+		date, err := parseDate(value)
+		if err == nil {
+			err = checkForecastLimit(date)
+		}
+		if err != nil {
+			params.invalid = append(params.invalid, params.invalidParameter("time", err.Error()))
+			return "", ""
+		}
+		return date, date
+	}
+
+	if _, ok := params.get("time"); ok {
+		params.invalid = append(params.invalid, params.invalidParameter("time", "give either time, or start and end"))
+	}
+
+	dates := make(map[string]string)
+	for _, name := range []string{"start", "end"} {
+		value, ok := params.get(name)
+		if !ok {
+			params.addMissing(name, "start and end must be given together")
+			continue
+		}
+
+		date, err := parseDate(value)
+		if err != nil {
+			params.invalid = append(params.invalid, params.invalidParameter(name, err.Error()))
+			continue
+		}
+		dates[name] = date
+	}
+	if len(dates) != 2 {
+		return "", ""
+	}
+
+	err := checkDateRange(dates["start"], dates["end"])
+	if err != nil {
+		params.invalid = append(params.invalid, params.invalidParameter("end", err.Error()))
+		return "", ""
+	}
+	return dates["start"], dates["end"]
+}
+
+// integer returns an optional integer parameter of at least the min value, or the default value if it is not given.
+func (params *queryParameters) integer(name string, defaultValue, minValue int) int {
+	value, ok := params.get(name)
+	if !ok {
+		return defaultValue
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < minValue {
+		params.invalid = append(params.invalid, params.invalidParameter(name, fmt.Sprintf("must be an integer of at least %d", minValue)))
+		return defaultValue
+	}
+	return number
+}
+
+// checkTypeName checks that the feature type the client asked for, if any, is the feature type of the forestry
+// roads in the WFS. Roads from the local store have no feature type, so any is accepted.
+// Returns the invalid parameter, or an error if the feature type of the WFS is not known.
+func checkTypeName(ctx context.Context, typeName models.InvalidParameter) ([]models.InvalidParameter, error) {
+	if typeName.Name == "" || roadstore.Enabled() {
+		return nil, nil
+	}
+
+	wfsTypeName, err := forestryroads.TypeName(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if sameTypeName(typeName.Value, wfsTypeName) {
+		return nil, nil
+	}
+
+	typeName.Message = "unknown feature type, the forestry roads are " + wfsTypeName
+	return []models.InvalidParameter{typeName}, nil
+}
+
+// sameTypeName checks if two feature type names are the same. The namespace prefix is only compared
+// when both names have one, ex: app:Skogsbilveg and Skogsbilveg are the same.
+func sameTypeName(a, b string) bool {
+	prefixA, localA, hasPrefixA := strings.Cut(a, ":")
+	prefixB, localB, hasPrefixB := strings.Cut(b, ":")
+	if !hasPrefixA {
+		localA = prefixA
+	}
+	if !hasPrefixB {
+		localB = prefixB
+	}
+	if hasPrefixA && hasPrefixB && prefixA != prefixB {
+		return false
+	}
+	return localA == localB
+}
+
+// writeQueryError writes the invalid parameters as a JSON error body.
+func writeQueryError(w http.ResponseWriter, r *http.Request, invalid []models.InvalidParameter) {
+	messages := make([]string, len(invalid))
+	for i, parameter := range invalid {
+		messages[i] = parameter.Name + ": " + parameter.Message
+	}
+	log.Warn().Str("request", r.URL.String()).Msg("Invalid URL parameters: " + strings.Join(messages, "; "))
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(models.QueryError{Message: "invalid URL parameters", Parameters: invalid})
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"skogkursbachelor/server/internal/models"
+	"slices"
+	"testing"
+)
+
+func TestParseForestryRoadsQuery(t *testing.T) {
+	const bbox = "bbox=261000,6647000,263000,6650000"
+
+	tests := []struct {
+		name  string
+		query string
+		want  []models.InvalidParameter
+	}{
+		{name: "time", query: bbox + "&time=2024-01-01"},
+		{name: "timestamp", query: bbox + "&time=2024-01-01T12:00:00Z"},
+		{name: "date range", query: bbox + "&start=2024-01-01&end=2024-01-31"},
+		{
+			name: "WFS parameters",
+			query: bbox + ",urn:ogc:def:crs:EPSG::25833&time=2024-01-01&SERVICE=WFS&REQUEST=GetFeature&VERSION=2.0.0" +
+				"&outputFormat=application/json&srsName=EPSG:25833&count=10&startIndex=20",
+		},
+		{
+			name:  "unknown parameter",
+			query: bbox + "&time=2024-01-01&foo=1",
+			want:  []models.InvalidParameter{{Name: "foo", Value: "1", Message: "unknown parameter"}},
+		},
+		{
+			name:  "repeated parameter is not missing",
+			query: bbox + "&time=2024-01-01&time=2024-01-02",
+			want:  []models.InvalidParameter{{Name: "time", Value: "2024-01-01", Message: "given more than once"}},
+		},
+		{
+			name:  "missing bbox",
+			query: "time=2024-01-01",
+			want:  []models.InvalidParameter{{Name: "bbox", Message: "missing, expected minx,miny,maxx,maxy in EPSG:25833"}},
+		},
+		{
+			name:  "missing time",
+			query: bbox,
+			want: []models.InvalidParameter{
+				{Name: "time", Message: "missing, expected a date formatted as YYYY-MM-DD, or start and end"},
+			},
+		},
+		{
+			name:  "invalid date",
+			query: bbox + "&time=2024-02-30",
+			want: []models.InvalidParameter{{
+				Name: "time", Value: "2024-02-30",
+				Message: "2024-02-30 is not an ISO 8601 date formatted as YYYY-MM-DD or YYYY-MM-DDTHH:MM:SSZ",
+			}},
+		},
+		{
+			name:  "invalid hour",
+			query: bbox + "&time=2024-01-01T25:00",
+			want: []models.InvalidParameter{{
+				Name: "time", Value: "2024-01-01T25:00",
+				Message: "2024-01-01T25:00 is not an ISO 8601 date formatted as YYYY-MM-DD or YYYY-MM-DDTHH:MM:SSZ",
+			}},
+		},
+		{
+			name:  "time and start",
+			query: bbox + "&time=2024-01-01&start=2024-01-01&end=2024-01-02",
+			want:  []models.InvalidParameter{{Name: "time", Value: "2024-01-01", Message: "give either time, or start and end"}},
+		},
+		{
+			name:  "start without end",
+			query: bbox + "&start=2024-01-01",
+			want:  []models.InvalidParameter{{Name: "end", Message: "missing, start and end must be given together"}},
+		},
+		{
+			name:  "end before start",
+			query: bbox + "&start=2024-01-02&end=2024-01-01",
+			want: []models.InvalidParameter{
+				{Name: "end", Value: "2024-01-01", Message: "end date 2024-01-01 is before start date 2024-01-02"},
+			},
+		},
+		{
+			name:  "count and startIndex bounds",
+			query: bbox + "&time=2024-01-01&count=0&startIndex=-1",
+			want: []models.InvalidParameter{
+				{Name: "count", Value: "0", Message: "must be an integer of at least 1"},
+				{Name: "startIndex", Value: "-1", Message: "must be an integer of at least 0"},
+			},
+		},
+		{
+			name:  "maxFeatures",
+			query: bbox + "&time=2024-01-01&maxFeatures=x",
+			want:  []models.InvalidParameter{{Name: "maxFeatures", Value: "x", Message: "must be an integer of at least 1"}},
+		},
+		{
+			name:  "invalid bbox",
+			query: "bbox=1,2,a,4&time=2024-01-01",
+			want:  []models.InvalidParameter{{Name: "bbox", Value: "1,2,a,4", Message: `"a" is not a number`}},
+		},
+		{
+			name:  "bbox min larger than max",
+			query: "bbox=3,2,1,4&time=2024-01-01",
+			want:  []models.InvalidParameter{{Name: "bbox", Value: "3,2,1,4", Message: "min is larger than max"}},
+		},
+		{
+			name:  "bbox larger than 100 km",
+			query: "bbox=100000,6600000,250000,6650000&time=2024-01-01",
+			want:  []models.InvalidParameter{{Name: "bbox", Value: "100000,6600000,250000,6650000", Message: "larger than 100 km"}},
+		},
+		{
+			name:  "WFS request",
+			query: bbox + "&time=2024-01-01&service=WMS&request=GetCapabilities&version=3.0.0&outputFormat=text/xml",
+			want: []models.InvalidParameter{
+				{Name: "outputFormat", Value: "text/xml", Message: "only GeoJSON is supported"},
+				{Name: "request", Value: "GetCapabilities", Message: "must be GetFeature"},
+				{Name: "service", Value: "WMS", Message: "must be WFS"},
+				{Name: "version", Value: "3.0.0", Message: "must be one of 1.0.0, 1.1.0, 2.0.0, 2.0.2"},
+			},
+		},
+		{
+			name:  "several type names",
+			query: bbox + "&time=2024-01-01&typeNames=a,b",
+			want:  []models.InvalidParameter{{Name: "typeNames", Value: "a,b", Message: "only one feature type is supported"}},
+		},
+		{
+			name:  "bbox outside Norway",
+			query: "bbox=-80,0,-70,1,CRS:84&time=2024-01-01",
+			want:  []models.InvalidParameter{{Name: "bbox", Value: "-80,0,-70,1,CRS:84", Message: "the bounding box is outside Norway"}},
+		},
+		{
+			name:  "bbox that can not be transformed",
+			query: "bbox=-75,0,10,60,CRS:84&time=2024-01-01",
+			want: []models.InvalidParameter{
+				{Name: "bbox", Value: "-75,0,10,60,CRS:84", Message: "the bounding box can not be transformed to EPSG:25833"},
+			},
+		},
+		{
+			name:  "crs is not srsName",
+			query: "bbox=1190000,8370000,1200000,8380000&time=2024-01-01&srsName=EPSG:3857&crs=EPSG:4326",
+			want:  []models.InvalidParameter{{Name: "crs", Value: "EPSG:4326", Message: "is not the CRS of srsName"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, got := parseForestryRoadsQuery(httptest.NewRequest("GET", "/?"+tt.query, nil))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("parseForestryRoadsQuery(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseForestryRoadsQueryValues(t *testing.T) {
+	query, invalid := parseForestryRoadsQuery(httptest.NewRequest(
+		"GET", "/?bbox=261000,6647000,263000,6650000&start=2024-01-01T06:00:00Z&end=2024-01-31&maxFeatures=10&startIndex=20", nil,
+	))
+	if len(invalid) > 0 {
+		t.Fatalf("parseForestryRoadsQuery returned invalid parameters %v", invalid)
+	}
+
+	if query.minX != 261000 || query.minY != 6647000 || query.maxX != 263000 || query.maxY != 6650000 {
+		t.Errorf("bbox = %v,%v,%v,%v, want 261000,6647000,263000,6650000", query.minX, query.minY, query.maxX, query.maxY)
+	}
+	if query.startDate != "2024-01-01" || query.endDate != "2024-01-31" {
+		t.Errorf("dates = %s to %s, want 2024-01-01 to 2024-01-31", query.startDate, query.endDate)
+	}
+	if query.count != 10 || query.startIndex != 20 {
+		t.Errorf("count, startIndex = %d, %d, want 10, 20", query.count, query.startIndex)
+	}
+	if !query.crs.IsInternal() {
+		t.Errorf("crs = %s, want EPSG:25833", query.crs.Code)
+	}
+}
+
+func TestParseForestryRoadsQueryAxisOrder(t *testing.T) {
+	// The same area in Oslo, latitude first in EPSG:4326 and longitude first in CRS84
+	tests := []string{
+		"bbox=59.9,10.7,60.0,10.8&crs=EPSG:4326",
+		"bbox=59.9,10.7,60.0,10.8,urn:ogc:def:crs:EPSG::4326",
+		"bbox=10.7,59.9,10.8,60.0&crs=CRS:84",
+	}
+
+	for _, query := range tests {
+		t.Run(query, func(t *testing.T) {
+			got, invalid := parseForestryRoadsQuery(httptest.NewRequest("GET", "/?time=2024-01-01&"+query, nil))
+			if len(invalid) > 0 {
+				t.Fatalf("parseForestryRoadsQuery returned invalid parameters %v", invalid)
+			}
+			if got.minX < 255000 || got.maxX > 270000 || got.minY < 6640000 || got.maxY > 6665000 {
+				t.Errorf("bbox = %v,%v,%v,%v, want around Oslo", got.minX, got.minY, got.maxX, got.maxY)
+			}
+		})
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "2024-03-01", want: "2024-03-01"},
+		{value: "2024-03-01T00:00:00Z", want: "2024-03-01"},
+		{value: "2024-03-01T23:59:59.5+01:00", want: "2024-03-01"},
+		{value: "2024-03-01T12:00:00", want: "2024-03-01"},
+		{value: "2024-03-01T12:00", want: "2024-03-01"},
+		{value: "2024-02-29", want: "2024-02-29"},
+		{value: "2023-02-29", wantErr: true},
+		{value: "2024-02-30", wantErr: true},
+		{value: "2024-13-01", wantErr: true},
+		{value: "2024-01-01T24:00:00Z", wantErr: true},
+		{value: "2024-01-01T12:60", wantErr: true},
+		{value: "01.03.2024", wantErr: true},
+		{value: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseDate(tt.value)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseDate(%q) = %q, %v, want %q, error %v", tt.value, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"skogkursbachelor/server/internal/http/upstream"
+	"skogkursbachelor/server/internal/projection"
+	"skogkursbachelor/server/internal/services/forestryroads"
+	"skogkursbachelor/server/internal/services/vectortiles"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// _implementedMethodsTiles is a list of the implemented HTTP methods for the vector tile endpoint.
+var _implementedMethodsTiles = []string{http.MethodGet}
+
+// _minTileZoom is the lowest zoom level with roads, a tile further out covers too many roads to enrich
+const _minTileZoom = 10
+
+// _maxTileZoom is the highest zoom level tiles are served at
+const _maxTileZoom = 22
+
+// _tileMaxAge is how long, in seconds, clients may cache a tile, SeNorge values for today are updated during the day
+const _tileMaxAge = 900
+
+// ForestryRoadTilesHandler handles requests for Mapbox Vector Tiles of enriched forestry roads,
+// at /tiles/{z}/{x}/{y}.mvt in the Web Mercator tile grid.
+func ForestryRoadTilesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Switch on the HTTP request method
+	switch r.Method {
+	case http.MethodGet:
+		handleForestryRoadTileGet(w, r)
+
+	default:
+		// If the method is not implemented, return an error with the allowed methods
+		http.Error(
+			w, fmt.Sprintf(
+				"REST Method '%s' not supported. Currently only '%v' are supported.", r.Method,
+				_implementedMethodsTiles,
+			), http.StatusNotImplemented,
+		)
+		return
+	}
+}
+
+// handleForestryRoadTileGet handles GET requests for a vector tile. The date is given like for the forestry road
+// endpoint, by time or start and end, and is today if none are given.
+func handleForestryRoadTileGet(w http.ResponseWriter, r *http.Request) {
+	z, x, y, err := getTile(r)
+	if err != nil {
+		log.Warn().Str("request", r.URL.String()).Msg(err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	startDate, endDate := time.Now().Format(time.DateOnly), time.Now().Format(time.DateOnly)
+	query := r.URL.Query()
+	if query.Has("time") || query.Has("start") || query.Has("end") {
+		startDate, endDate, err = getDateRange(r)
+		if err != nil {
+			log.Warn().Str("request", r.URL.String()).Msg(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("content-type", "application/vnd.mapbox-vector-tile")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(_tileMaxAge))
+
+	// An empty body is an empty tile
+	if z < _minTileZoom {
+		return
+	}
+
+	minX, minY, maxX, maxY := projection.TileBounds(z, x, y)
+	webMercator, _ := projection.ParseCRS("EPSG:3857")
+	minX, minY, maxX, maxY, err = webMercator.CheckedBoundsToInternal(minX, minY, maxX, maxY)
+	if err != nil {
+		// Tiles outside Norway are empty
+		return
+	}
+
+	response, err := forestryroads.GetEnrichedRoads(r.Context(), minX, minY, maxX, maxY, startDate, endDate)
+	if !writeForestryRoadsError(w, r, err) {
+		return
+	}
+	for _, warning := range response.Warnings {
+		log.Debug().Str("request", r.URL.String()).Msgf("%s: %s", warning.Source, warning.Message)
+	}
+
+	_, err = w.Write(vectortiles.EncodeRoads(response.Features, z, x, y))
+	if err != nil {
+		log.Error().Msg("Error writing vector tile: " + err.Error())
+	}
+}
+
+// writeForestryRoadsError writes the error from getting forestry roads to the response writer.
+// Returns true if there is no error. Abandoned requests get no response.
+func writeForestryRoadsError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, context.Canceled) || r.Context().Err() != nil:
+		log.Debug().Str("request", r.URL.String()).Msg("Request abandoned while getting forestry roads")
+	case errors.Is(err, upstream.ErrCircuitOpen):
+		http.Error(w, "The forestry road source is unavailable, try again later", http.StatusServiceUnavailable)
+		log.Error().Msg("Skipped request to the forestry road source: " + err.Error())
+	default:
+		http.Error(w, "Failed to get forestry roads", http.StatusBadGateway)
+		log.Error().Msg("Error getting forestry roads: " + err.Error())
+	}
+	return false
+}
+
+// getTile returns the z, x and y of the tile in the path, the last segment is "{y}.mvt".
+func getTile(r *http.Request) (int, int, int, error) {
+	yFile := r.PathValue("y")
+	if !strings.HasSuffix(yFile, ".mvt") {
+		return 0, 0, 0, fmt.Errorf("tiles must be requested as {z}/{x}/{y}.mvt")
+	}
+
+	var numbers [3]int
+	for i, value := range []string{r.PathValue("z"), r.PathValue("x"), strings.TrimSuffix(yFile, ".mvt")} {
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 0 {
+			return 0, 0, 0, fmt.Errorf("invalid tile %s/%s/%s", r.PathValue("z"), r.PathValue("x"), yFile)
+		}
+		numbers[i] = number
+	}
+
+	z, x, y := numbers[0], numbers[1], numbers[2]
+	if z > _maxTileZoom {
+		return 0, 0, 0, fmt.Errorf("zoom %d is above the highest zoom level %d", z, _maxTileZoom)
+	}
+	if x >= 1<<z || y >= 1<<z {
+		return 0, 0, 0, fmt.Errorf("tile %d/%d/%d is outside the tile grid", z, x, y)
+	}
+
+	return z, x, y, nil
+}
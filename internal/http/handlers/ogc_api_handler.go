@@ -0,0 +1,415 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"skogkursbachelor/server/internal/constants"
+	"skogkursbachelor/server/internal/models"
+	"skogkursbachelor/server/internal/projection"
+	"skogkursbachelor/server/internal/services/forestryroads"
+	"skogkursbachelor/server/internal/services/roadstore"
+	"skogkursbachelor/server/internal/services/senorge"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// _implementedMethodsOGC is a list of the implemented HTTP methods for the OGC API endpoints.
+var _implementedMethodsOGC = []string{http.MethodGet}
+
+// _collectionID is the id of the forestry road collection
+const _collectionID = "forestryroads"
+
+// Paging of the items of the collection
+const (
+	_defaultItemsLimit = 100
+	_maxItemsLimit     = 1000
+)
+
+// _crs84 is the CRS of the OGC API, longitude and latitude in degrees
+const _crs84 = "http://www.opengis.net/def/crs/OGC/1.3/CRS84"
+
+// _conformanceClasses are the OGC API Features conformance classes the server implements
+var _conformanceClasses = []string{
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/core",
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/geojson",
+	"http://www.opengis.net/spec/ogcapi-features-2/1.0/conf/crs",
+}
+
+// OGCLandingPageHandler handles requests to the landing page of the OGC API.
+func OGCLandingPageHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkOGCMethod(w, r) {
+		return
+	}
+
+	api := baseURL(r) + constants.APIPath
+	writeOGCResponse(w, "application/json", models.LandingPage{
+		Title:       "Skogkurs forestry roads",
+		Description: "Forestry roads enriched with frost depth, water saturation, superficial deposits and trafficability",
+		Links: []models.Link{
+			{Href: api, Rel: "self", Type: "application/json", Title: "This document"},
+			{Href: api + "conformance", Rel: "conformance", Type: "application/json", Title: "Conformance classes"},
+			{Href: api + "collections", Rel: "data", Type: "application/json", Title: "Collections"},
+		},
+	})
+}
+
+// OGCConformanceHandler handles requests for the conformance classes of the OGC API.
+func OGCConformanceHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkOGCMethod(w, r) {
+		return
+	}
+
+	writeOGCResponse(w, "application/json", models.Conformance{ConformsTo: _conformanceClasses})
+}
+
+// OGCCollectionsHandler handles requests for the collections of the OGC API, which is only the forestry roads.
+func OGCCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkOGCMethod(w, r) {
+		return
+	}
+
+	writeOGCResponse(w, "application/json", models.Collections{
+		Links:       []models.Link{{Href: requestURL(r), Rel: "self", Type: "application/json"}},
+		Collections: []models.Collection{forestryRoadCollection(r)},
+	})
+}
+
+// OGCCollectionHandler handles requests for a collection of the OGC API.
+func OGCCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkOGCMethod(w, r) || !checkOGCCollection(w, r) {
+		return
+	}
+
+	writeOGCResponse(w, "application/json", forestryRoadCollection(r))
+}
+
+// OGCItemsHandler handles requests for a page of enriched roads, in the bbox and for the datetime.
+// Only the roads of the page are enriched. Without a bbox, every road in the local store is paged through,
+// roads from the WFS need a bbox. The bbox is in the CRS of bbox-crs and the roads in the CRS of crs, CRS84 by default.
+func OGCItemsHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkOGCMethod(w, r) || !checkOGCCollection(w, r) {
+		return
+	}
+	query := r.URL.Query()
+
+	crs, err := getOGCCRS(query, "crs")
+	if err != nil {
+		writeOGCException(w, r, http.StatusBadRequest, "InvalidParameterValue", "invalid crs: "+err.Error())
+		return
+	}
+
+	startDate, endDate, err := getDatetime(query.Get("datetime"))
+	if err != nil {
+		writeOGCException(w, r, http.StatusBadRequest, "InvalidParameterValue", "invalid datetime: "+err.Error())
+		return
+	}
+
+	limit, err := getIntParameter(query, "limit", _defaultItemsLimit, 1)
+	if err != nil {
+		writeOGCException(w, r, http.StatusBadRequest, "InvalidParameterValue", err.Error())
+		return
+	}
+	limit = min(limit, _maxItemsLimit)
+
+	offset, err := getIntParameter(query, "offset", 0, 0)
+	if err != nil {
+		writeOGCException(w, r, http.StatusBadRequest, "InvalidParameterValue", err.Error())
+		return
+	}
+
+	var roads []models.ForestRoad
+	if query.Has("bbox") {
+		bboxCRS, err := getOGCCRS(query, "bbox-crs")
+		if err != nil {
+			writeOGCException(w, r, http.StatusBadRequest, "InvalidParameterValue", "invalid bbox-crs: "+err.Error())
+			return
+		}
+
+		minX, minY, maxX, maxY, err := getOGCBBox(query.Get("bbox"), bboxCRS)
+		if err == nil && (maxX-minX > _maxBBoxSize || maxY-minY > _maxBBoxSize) {
+			err = fmt.Errorf("bbox is larger than %d km", _maxBBoxSize/1000)
+		}
+		if err != nil {
+			writeOGCException(w, r, http.StatusBadRequest, "InvalidParameterValue", "invalid bbox: "+err.Error())
+			return
+		}
+
+		response, err := forestryroads.GetRoads(r.Context(), minX, minY, maxX, maxY)
+		if !writeForestryRoadsError(w, r, err) {
+			return
+		}
+		roads = response.Features
+	} else if roadstore.Enabled() {
+		roads, err = roadstore.All()
+		if err != nil {
+			writeOGCException(w, r, http.StatusInternalServerError, "ServerError", "failed to read forestry roads")
+			log.Error().Msg("Error reading forestry road store: " + err.Error())
+			return
+		}
+	} else {
+		writeOGCException(w, r, http.StatusBadRequest, "MissingParameterValue", "bbox is required")
+		return
+	}
+
+	// Page through the roads in the order of their ids
+	forestryroads.SetFeatureIDs(roads)
+	sort.Slice(roads, func(i, j int) bool { return roads[i].ID < roads[j].ID })
+	offset = min(offset, len(roads))
+	end := offset + min(limit, len(roads)-offset)
+	page := append([]models.ForestRoad{}, roads[offset:end]...)
+
+	// Failed enrichments are left as null, and described in the warnings of the response
+	warnings, err := forestryroads.Enrich(r.Context(), page, startDate, endDate)
+	if err != nil {
+		log.Debug().Str("request", r.URL.String()).Msg("Request abandoned during enrichment")
+		return
+	}
+	forestryroads.ReprojectRoads(page, crs)
+
+	links := []models.Link{
+		{Href: pageURL(r, offset), Rel: "self", Type: "application/geo+json"},
+		{Href: baseURL(r) + constants.CollectionsPath + "/" + _collectionID, Rel: "collection", Type: "application/json"},
+	}
+	if end < len(roads) {
+		links = append(links, models.Link{Href: pageURL(r, end), Rel: "next", Type: "application/geo+json"})
+	}
+	if offset > 0 {
+		links = append(links, models.Link{Href: pageURL(r, max(offset-limit, 0)), Rel: "prev", Type: "application/geo+json"})
+	}
+
+	w.Header().Set("Content-Crs", "<"+crs.URI()+">")
+	writeOGCResponse(w, "application/geo+json", models.FeatureCollection{
+		Type:           "FeatureCollection",
+		NumberMatched:  len(roads),
+		NumberReturned: len(page),
+		TimeStamp:      time.Now().UTC().Format(time.RFC3339),
+		Enheter:        senorge.Units(),
+		Warnings:       warnings,
+		Links:          links,
+		Features:       page,
+	})
+}
+
+// OGCItemHandler handles requests for a single enriched road, by the id it has in the items of the collection.
+// The road is in the CRS of crs, CRS84 by default.
+func OGCItemHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkOGCMethod(w, r) || !checkOGCCollection(w, r) {
+		return
+	}
+
+	crs, err := getOGCCRS(r.URL.Query(), "crs")
+	if err != nil {
+		writeOGCException(w, r, http.StatusBadRequest, "InvalidParameterValue", "invalid crs: "+err.Error())
+		return
+	}
+
+	startDate, endDate, err := getDatetime(r.URL.Query().Get("datetime"))
+	if err != nil {
+		writeOGCException(w, r, http.StatusBadRequest, "InvalidParameterValue", "invalid datetime: "+err.Error())
+		return
+	}
+
+	id := r.PathValue("featureId")
+	road, warnings, found, err := forestryroads.GetEnrichedRoad(r.Context(), id, startDate, endDate)
+	if !writeForestryRoadsError(w, r, err) {
+		return
+	}
+	if !found {
+		writeOGCException(w, r, http.StatusNotFound, "NotFound", "no forestry road with id "+id)
+		return
+	}
+	for _, warning := range warnings {
+		log.Debug().Str("request", r.URL.String()).Msgf("%s: %s", warning.Source, warning.Message)
+	}
+
+	roads := []models.ForestRoad{road}
+	forestryroads.ReprojectRoads(roads, crs)
+
+	collectionURL := baseURL(r) + constants.CollectionsPath + "/" + _collectionID
+	w.Header().Set("Content-Crs", "<"+crs.URI()+">")
+	writeOGCResponse(w, "application/geo+json", struct {
+		models.ForestRoad
+		Links []models.Link `json:"links"`
+	}{
+		ForestRoad: roads[0],
+		Links: []models.Link{
+			{Href: requestURL(r), Rel: "self", Type: "application/geo+json"},
+			{Href: collectionURL, Rel: "collection", Type: "application/json"},
+		},
+	})
+}
+
+// forestryRoadCollection describes the forestry road collection.
+func forestryRoadCollection(r *http.Request) models.Collection {
+	collectionURL := baseURL(r) + constants.CollectionsPath + "/" + _collectionID
+
+	collection := models.Collection{
+		ID:          _collectionID,
+		Title:       "Skogsbilveger",
+		Description: "Forestry roads enriched with SeNorge data for the datetime, superficial deposits and trafficability",
+		Links: []models.Link{
+			{Href: collectionURL, Rel: "self", Type: "application/json"},
+			{Href: collectionURL + "/items", Rel: "items", Type: "application/geo+json"},
+		},
+		ItemType: "feature",
+	}
+	// Roads are stored in the internal CRS, the first of the supported CRSs
+	for _, code := range projection.SupportedCRS() {
+		crs, _ := projection.ParseCRS(code)
+		collection.Crs = append(collection.Crs, crs.URI())
+	}
+	collection.StorageCrs = collection.Crs[0]
+	collection.Extent.Spatial.Bbox = [][]float64{{4.0, 57.8, 31.5, 71.5}}
+	collection.Extent.Spatial.Crs = _crs84
+	firstDate := "1957-01-01T00:00:00Z"
+	collection.Extent.Temporal.Interval = [][]*string{{&firstDate, nil}}
+
+	return collection
+}
+
+// getDatetime returns the start and end date of an OGC API datetime, a date or a closed interval "start/end".
+// Dates may have a time, which is ignored. Without a datetime, the date is today.
+func getDatetime(datetime string) (string, string, error) {
+	if datetime == "" {
+		today := time.Now().Format(time.DateOnly)
+		return today, today, nil
+	}
+
+	start, end, isInterval := strings.Cut(datetime, "/")
+	if !isInterval {
+		date, err := parseDate(datetime)
+		if err != nil {
+			return "", "", err
+		}
+		return date, date, checkForecastLimit(date)
+	}
+
+	if start == "" || start == ".." || end == "" || end == ".." {
+		return "", "", fmt.Errorf("open intervals are not supported")
+	}
+
+	startDate, err := parseDate(start)
+	if err != nil {
+		return "", "", err
+	}
+	endDate, err := parseDate(end)
+	if err != nil {
+		return "", "", err
+	}
+
+	return startDate, endDate, checkDateRange(startDate, endDate)
+}
+
+// getOGCCRS returns the CRS of a crs or bbox-crs URL parameter, or CRS84 if it is not set.
+func getOGCCRS(query url.Values, name string) (projection.CRS, error) {
+	if !query.Has(name) {
+		return projection.ParseCRS("CRS:84")
+	}
+	return projection.ParseCRS(query.Get(name))
+}
+
+// getOGCBBox parses an OGC API bbox in the CRS, in the axis order of the CRS, ex: "minlon,minlat,maxlon,maxlat"
+// in CRS84 and "minlat,minlon,maxlat,maxlon" in EPSG:4326. Returns the EPSG:25833 bbox covering it.
+func getOGCBBox(bbox string, crs projection.CRS) (float64, float64, float64, float64, error) {
+	parts := strings.Split(bbox, ",")
+	if len(parts) != 4 {
+		return 0, 0, 0, 0, fmt.Errorf("expected 4 numbers")
+	}
+
+	var numbers [4]float64
+	for i, part := range parts {
+		number, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+			return 0, 0, 0, 0, fmt.Errorf("expected 4 numbers")
+		}
+		numbers[i] = number
+	}
+	if crs.LatLon {
+		numbers = [4]float64{numbers[1], numbers[0], numbers[3], numbers[2]}
+	}
+
+	if crs.Geographic && (numbers[0] < -180 || numbers[2] > 180 || numbers[1] < -90 || numbers[3] > 90) {
+		return 0, 0, 0, 0, fmt.Errorf("coordinates must be longitude and latitude in degrees")
+	}
+	if numbers[2] < numbers[0] || numbers[3] < numbers[1] {
+		return 0, 0, 0, 0, fmt.Errorf("min is larger than max")
+	}
+
+	return crs.CheckedBoundsToInternal(numbers[0], numbers[1], numbers[2], numbers[3])
+}
+
+// getIntParameter returns an integer URL parameter, or the default value if it is not set.
+func getIntParameter(query url.Values, name string, defaultValue, minValue int) (int, error) {
+	if !query.Has(name) {
+		return defaultValue, nil
+	}
+
+	value, err := strconv.Atoi(query.Get(name))
+	if err != nil || value < minValue {
+		return 0, fmt.Errorf("%s must be an integer of at least %d", name, minValue)
+	}
+	return value, nil
+}
+
+// pageURL returns the address of the request with another offset.
+func pageURL(r *http.Request, offset int) string {
+	query := r.URL.Query()
+	query.Set("offset", strconv.Itoa(offset))
+	return requestURL(r) + "?" + query.Encode()
+}
+
+// checkOGCMethod checks that the request is a GET request, and writes an error otherwise.
+func checkOGCMethod(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method == http.MethodGet {
+		return true
+	}
+
+	// If the method is not implemented, return an error with the allowed methods
+	http.Error(
+		w, fmt.Sprintf(
+			"REST Method '%s' not supported. Currently only '%v' are supported.", r.Method,
+			_implementedMethodsOGC,
+		), http.StatusNotImplemented,
+	)
+	return false
+}
+
+// checkOGCCollection checks that the collection in the path is the forestry road collection,
+// and writes a not found error otherwise.
+func checkOGCCollection(w http.ResponseWriter, r *http.Request) bool {
+	if r.PathValue("collectionId") == _collectionID {
+		return true
+	}
+
+	writeOGCException(w, r, http.StatusNotFound, "NotFound", "no collection with id "+r.PathValue("collectionId"))
+	return false
+}
+
+// writeOGCResponse encodes the body as JSON with the content type.
+func writeOGCResponse(w http.ResponseWriter, contentType string, body interface{}) {
+	w.Header().Set("content-type", contentType)
+
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		log.Error().Msg("Error encoding OGC API response: " + err.Error())
+	}
+}
+
+// writeOGCException writes an OGC API exception with the status.
+func writeOGCException(w http.ResponseWriter, r *http.Request, status int, code, description string) {
+	log.Warn().Str("request", r.URL.String()).Msg(description)
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(models.OGCException{Code: code, Description: description})
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"errors"
+	"skogkursbachelor/server/internal/projection"
+	"testing"
+)
+
+func TestGetOGCBBox(t *testing.T) {
+	tests := []struct {
+		name    string
+		bbox    string
+		crs     string
+		wantErr error
+		wantOK  bool
+	}{
+		{name: "CRS84", bbox: "10.7,59.9,10.8,60.0", crs: "CRS:84", wantOK: true},
+		{name: "EPSG:4326 is latitude first", bbox: "59.9,10.7,60.0,10.8", crs: "EPSG:4326", wantOK: true},
+		{name: "EPSG:4326 longitude first", bbox: "10.7,59.9,10.8,60.0", crs: "EPSG:4326", wantErr: projection.ErrOutsideNorway},
+		{name: "outside Norway", bbox: "-80,0,-70,1", crs: "CRS:84", wantErr: projection.ErrOutsideNorway},
+		{name: "can not be transformed", bbox: "-75,0,10,60", crs: "CRS:84"},
+		{name: "not finite", bbox: "261000,6647000,263000,Inf", crs: "EPSG:25833"},
+		{name: "not degrees", bbox: "10,59,200,60", crs: "CRS:84"},
+		{name: "min larger than max", bbox: "10.8,59.9,10.7,60.0", crs: "CRS:84"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			crs, err := projection.ParseCRS(tt.crs)
+			if err != nil {
+				t.Fatalf("ParseCRS(%q) returned %v", tt.crs, err)
+			}
+
+			minX, minY, maxX, maxY, err := getOGCBBox(tt.bbox, crs)
+			if (err == nil) != tt.wantOK || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Fatalf("getOGCBBox(%q, %s) returned %v, want %v", tt.bbox, tt.crs, err, tt.wantErr)
+			}
+			if err == nil && (minX < 255000 || maxX > 270000 || minY < 6640000 || maxY > 6665000) {
+				t.Errorf("getOGCBBox(%q, %s) = %v,%v,%v,%v, want around Oslo", tt.bbox, tt.crs, minX, minY, maxX, maxY)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"skogkursbachelor/server/internal/http/upstream"
 
 	"github.com/rs/zerolog/log"
 )
@@ -22,7 +23,7 @@ func (p *Proxy) ProxyHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create the request
-	proxyReq, err := http.NewRequest(r.Method, remoteURL.String()+"?"+r.URL.RawQuery, r.Body)
+	proxyReq, err := http.NewRequestWithContext(r.Context(), r.Method, remoteURL.String()+"?"+r.URL.RawQuery, r.Body)
 	if err != nil {
 		log.Error().Msg("Error creating request: " + err.Error())
 		http.Error(w, "Failed to create request", http.StatusInternalServerError)
@@ -37,7 +38,7 @@ func (p *Proxy) ProxyHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Make the request
-	resp, err := http.DefaultClient.Do(proxyReq)
+	resp, err := upstream.Do(proxyReq)
 	if err != nil {
 		log.Error().Msg("Error making request: " + err.Error())
 		http.Error(w, "Failed to fetch data from WMS server", http.StatusBadGateway)
@@ -0,0 +1,405 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"image/png"
+	"math"
+	"net/http"
+	"skogkursbachelor/server/internal/models"
+	"skogkursbachelor/server/internal/projection"
+	"skogkursbachelor/server/internal/services/forestryroads"
+	"skogkursbachelor/server/internal/services/wms"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// _implementedMethodsWMS is a list of the implemented HTTP methods for the WMS endpoint.
+var _implementedMethodsWMS = []string{http.MethodGet}
+
+// _maxMapSize is the widest and tallest area, in meters, roads are drawn for. Larger maps are empty,
+// see wms.MaxScaleDenominator
+const _maxMapSize = 50000
+
+// wmsError is an error that is reported as a WMS service exception, with one of the WMS exception codes.
+type wmsError struct {
+	code    string
+	message string
+}
+
+func (e *wmsError) Error() string {
+	return e.message
+}
+
+// wmsMapRequest holds the parameters shared by GetMap and GetFeatureInfo.
+type wmsMapRequest struct {
+	view       wms.View
+	style      wms.Style
+	date       string
+	background *color.NRGBA
+}
+
+// WMSHandler handles requests to the WMS 1.3.0 endpoint, with GetCapabilities, GetMap and GetFeatureInfo.
+// The single layer draws the enriched forestry roads, with a style per enriched property.
+func WMSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Switch on the HTTP request method
+	switch r.Method {
+	case http.MethodGet:
+		handleWMSGet(w, r)
+
+	default:
+		// If the method is not implemented, return an error with the allowed methods
+		http.Error(
+			w, fmt.Sprintf(
+				"REST Method '%s' not supported. Currently only '%v' are supported.", r.Method,
+				_implementedMethodsWMS,
+			), http.StatusNotImplemented,
+		)
+		return
+	}
+}
+
+// handleWMSGet handles GET requests to the WMS endpoint, by the REQUEST parameter.
+func handleWMSGet(w http.ResponseWriter, r *http.Request) {
+	params := getWMSParams(r)
+
+	if service := params["service"]; service != "" && !strings.EqualFold(service, "WMS") {
+		writeWMSException(w, r, &wmsError{"", "SERVICE must be WMS"})
+		return
+	}
+
+	switch strings.ToLower(params["request"]) {
+	case "getcapabilities":
+		handleWMSGetCapabilities(w, r)
+	case "getmap":
+		handleWMSGetMap(w, r, params)
+	case "getfeatureinfo":
+		handleWMSGetFeatureInfo(w, r, params)
+	case "":
+		writeWMSException(w, r, &wmsError{"", "missing REQUEST parameter"})
+	default:
+		writeWMSException(w, r, &wmsError{"OperationNotSupported", "unsupported REQUEST " + params["request"]})
+	}
+}
+
+// handleWMSGetCapabilities writes the capabilities document, with the address of this endpoint.
+func handleWMSGetCapabilities(w http.ResponseWriter, r *http.Request) {
+	lastDate := time.Now().AddDate(0, 0, _maxForecastDays).Format(time.DateOnly)
+	capabilities, err := wms.Capabilities(requestURL(r), time.Now().Format(time.DateOnly), lastDate)
+	if err != nil {
+		http.Error(w, "Failed to write capabilities", http.StatusInternalServerError)
+		log.Error().Msg("Error writing WMS capabilities: " + err.Error())
+		return
+	}
+
+	w.Header().Set("content-type", "text/xml")
+	_, err = w.Write(capabilities)
+	if err != nil {
+		log.Error().Msg("Error writing WMS capabilities: " + err.Error())
+	}
+}
+
+// handleWMSGetMap draws the enriched roads in the bbox as a PNG image.
+func handleWMSGetMap(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	mapRequest, err := parseWMSMapRequest(params)
+	if err == nil && params["format"] != "image/png" {
+		err = &wmsError{"InvalidFormat", "unsupported FORMAT " + params["format"] + ", only image/png is supported"}
+	}
+	if err != nil {
+		writeWMSException(w, r, err)
+		return
+	}
+
+	response, ok := getWMSRoads(w, r, mapRequest)
+	if !ok {
+		return
+	}
+
+	img := wms.Render(response.Features, mapRequest.view, mapRequest.style, mapRequest.background)
+
+	w.Header().Set("content-type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(_tileMaxAge))
+	err = png.Encode(w, img)
+	if err != nil {
+		log.Error().Msg("Error encoding WMS map: " + err.Error())
+	}
+}
+
+// handleWMSGetFeatureInfo returns the enriched roads at a pixel of a map, as GeoJSON in the CRS of the map,
+// or as text.
+func handleWMSGetFeatureInfo(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	mapRequest, err := parseWMSMapRequest(params)
+	if err != nil {
+		writeWMSException(w, r, err)
+		return
+	}
+
+	if err = checkWMSLayers(params["query_layers"], "QUERY_LAYERS"); err != nil {
+		writeWMSException(w, r, err)
+		return
+	}
+
+	infoFormat := params["info_format"]
+	if infoFormat == "" {
+		infoFormat = "application/json"
+	}
+	if infoFormat != "application/json" && infoFormat != "text/plain" {
+		writeWMSException(w, r, &wmsError{"InvalidFormat", "unsupported INFO_FORMAT " + infoFormat})
+		return
+	}
+
+	i, errI := strconv.Atoi(params["i"])
+	j, errJ := strconv.Atoi(params["j"])
+	if errI != nil || errJ != nil || i < 0 || j < 0 || i >= mapRequest.view.Width || j >= mapRequest.view.Height {
+		writeWMSException(w, r, &wmsError{"InvalidPoint", "I and J must be a pixel in the map"})
+		return
+	}
+
+	count := 1
+	if params["feature_count"] != "" {
+		count, err = strconv.Atoi(params["feature_count"])
+		if err != nil || count < 1 {
+			writeWMSException(w, r, &wmsError{"", "FEATURE_COUNT must be a positive integer"})
+			return
+		}
+	}
+
+	response, ok := getWMSRoads(w, r, mapRequest)
+	if !ok {
+		return
+	}
+
+	response.Features = wms.FeatureInfo(response.Features, mapRequest.view, i, j, count)
+	response.NumberMatched = len(response.Features)
+	forestryroads.Reproject(&response, mapRequest.view.CRS)
+
+	if infoFormat == "text/plain" {
+		w.Header().Set("content-type", "text/plain; charset=utf-8")
+		writeFeatureInfoText(w, response.Features)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		log.Error().Msg("Error encoding WMS feature info: " + err.Error())
+	}
+}
+
+// getWMSRoads returns the enriched roads of the map. Maps outside Norway, or larger than _maxMapSize, have no roads.
+// On failure, the error is written to the response writer and false is returned.
+func getWMSRoads(w http.ResponseWriter, r *http.Request, mapRequest wmsMapRequest) (models.WFSResponse, bool) {
+	minX, minY, maxX, maxY, err := mapRequest.view.InternalBounds()
+	if err != nil || maxX-minX > _maxMapSize || maxY-minY > _maxMapSize {
+		return models.WFSResponse{Type: "FeatureCollection", Name: "forestryroads", Features: []models.ForestRoad{}}, true
+	}
+
+	response, err := forestryroads.GetEnrichedRoads(r.Context(), minX, minY, maxX, maxY, mapRequest.date, mapRequest.date)
+	if !writeForestryRoadsError(w, r, err) {
+		return models.WFSResponse{}, false
+	}
+	for _, warning := range response.Warnings {
+		log.Debug().Str("request", r.URL.String()).Msgf("%s: %s", warning.Source, warning.Message)
+	}
+
+	return response, true
+}
+
+// parseWMSMapRequest reads and checks the parameters shared by GetMap and GetFeatureInfo.
+func parseWMSMapRequest(params map[string]string) (wmsMapRequest, error) {
+	var mapRequest wmsMapRequest
+
+	if version := params["version"]; version != "" && version != wms.Version {
+		return mapRequest, &wmsError{"", "unsupported VERSION " + version + ", only " + wms.Version + " is supported"}
+	}
+
+	if err := checkWMSLayers(params["layers"], "LAYERS"); err != nil {
+		return mapRequest, err
+	}
+
+	// One style per layer, the layer is the same in every item
+	styleName := ""
+	for _, name := range strings.Split(params["styles"], ",") {
+		if name != "" {
+			styleName = name
+		}
+	}
+	style, ok := wms.GetStyle(styleName)
+	if !ok {
+		return mapRequest, &wmsError{"StyleNotDefined", "unknown STYLES " + params["styles"]}
+	}
+	mapRequest.style = style
+
+	if params["crs"] == "" {
+		return mapRequest, &wmsError{"", "missing CRS parameter"}
+	}
+	crs, err := projection.ParseCRS(params["crs"])
+	if err != nil {
+		return mapRequest, &wmsError{"InvalidCRS", err.Error()}
+	}
+
+	parts := strings.Split(params["bbox"], ",")
+	if len(parts) != 4 {
+		return mapRequest, &wmsError{"", "BBOX must be minx,miny,maxx,maxy"}
+	}
+	var bbox [4]float64
+	for i, part := range parts {
+		bbox[i], err = strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(bbox[i]) || math.IsInf(bbox[i], 0) {
+			return mapRequest, &wmsError{"", "BBOX must be minx,miny,maxx,maxy"}
+		}
+	}
+	if bbox[2] <= bbox[0] || bbox[3] <= bbox[1] {
+		return mapRequest, &wmsError{"", "BBOX min is not less than max"}
+	}
+
+	// EPSG:4326 is latitude first in WMS 1.3.0
+	if crs.LatLon {
+		bbox = [4]float64{bbox[1], bbox[0], bbox[3], bbox[2]}
+	}
+
+	width, errWidth := strconv.Atoi(params["width"])
+	height, errHeight := strconv.Atoi(params["height"])
+	if errWidth != nil || errHeight != nil || width < 1 || height < 1 || width > wms.MaxSize || height > wms.MaxSize {
+		return mapRequest, &wmsError{"", fmt.Sprintf("WIDTH and HEIGHT must be from 1 to %d", wms.MaxSize)}
+	}
+
+	mapRequest.view = wms.View{
+		CRS:    crs,
+		MinX:   bbox[0],
+		MinY:   bbox[1],
+		MaxX:   bbox[2],
+		MaxY:   bbox[3],
+		Width:  width,
+		Height: height,
+	}
+
+	// A map outside Norway is empty, as WMS 1.3.0 requires for a BBOX that does not overlap the layer
+	_, _, _, _, err = mapRequest.view.InternalBounds()
+	if err != nil && !errors.Is(err, projection.ErrOutsideNorway) {
+		return mapRequest, &wmsError{"", "invalid BBOX: " + err.Error()}
+	}
+
+	// The time dimension, today by default
+	mapRequest.date = time.Now().Format(time.DateOnly)
+	if params["time"] != "" {
+		mapRequest.date, err = parseDate(params["time"])
+		if err == nil {
+			err = checkForecastLimit(mapRequest.date)
+		}
+		if err != nil {
+			return mapRequest, &wmsError{"InvalidDimensionValue", "invalid TIME: " + err.Error()}
+		}
+	}
+
+	// Transparent by default, otherwise filled with the background color
+	if strings.EqualFold(params["transparent"], "false") {
+		background := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+		if params["bgcolor"] != "" {
+			rgb, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(params["bgcolor"]), "0x"), 16, 32)
+			if err != nil || len(params["bgcolor"]) != 8 {
+				return mapRequest, &wmsError{"", "BGCOLOR must be formatted as 0xRRGGBB"}
+			}
+			background = color.NRGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 255}
+		}
+		mapRequest.background = &background
+	}
+
+	return mapRequest, nil
+}
+
+// checkWMSLayers checks that the layers parameter only names the forestry road layer.
+func checkWMSLayers(layers, name string) error {
+	if layers == "" {
+		return &wmsError{"", "missing " + name + " parameter"}
+	}
+	for _, layer := range strings.Split(layers, ",") {
+		if layer != wms.LayerName {
+			return &wmsError{"LayerNotDefined", "unknown layer " + layer + " in " + name}
+		}
+	}
+	return nil
+}
+
+// getWMSParams returns the URL parameters by lowercase name, WMS parameter names are case insensitive.
+func getWMSParams(r *http.Request) map[string]string {
+	params := make(map[string]string)
+	for key, values := range r.URL.Query() {
+		if len(values) > 0 {
+			params[strings.ToLower(key)] = values[0]
+		}
+	}
+	return params
+}
+
+// writeWMSException writes the error as a WMS service exception report.
+func writeWMSException(w http.ResponseWriter, r *http.Request, err error) {
+	log.Warn().Str("request", r.URL.String()).Msg(err.Error())
+
+	code := ""
+	if e, ok := err.(*wmsError); ok {
+		code = e.code
+	}
+
+	w.Header().Set("content-type", "text/xml")
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = w.Write(wms.ExceptionReport(code, err.Error()))
+}
+
+// writeFeatureInfoText writes the properties of the roads as "key = value" lines, sorted by key.
+func writeFeatureInfoText(w http.ResponseWriter, roads []models.ForestRoad) {
+	_, _ = fmt.Fprintf(w, "Layer '%s'\n", wms.LayerName)
+	for i, road := range roads {
+		_, _ = fmt.Fprintf(w, "  Feature %d:\n", i+1)
+
+		data, err := json.Marshal(road.Properties)
+		if err != nil {
+			log.Error().Msg("Error encoding WMS feature info: " + err.Error())
+			continue
+		}
+		var properties map[string]json.RawMessage
+		_ = json.Unmarshal(data, &properties)
+
+		keys := make([]string, 0, len(properties))
+		for key := range properties {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			_, _ = fmt.Fprintf(w, "    %s = %s\n", key, properties[key])
+		}
+	}
+}
+
+// requestURL returns the address of the request without the query, as clients reach it, also behind a proxy.
+func requestURL(r *http.Request) string {
+	return baseURL(r) + r.URL.Path
+}
+
+// baseURL returns the scheme and host of the request, as clients reach it, also behind a proxy.
+// ex: https://example.com
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+
+	host := r.Host
+	if forwardedHost := r.Header.Get("X-Forwarded-Host"); forwardedHost != "" {
+		host = forwardedHost
+	}
+
+	return scheme + "://" + host
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseWMSMapRequestBBox(t *testing.T) {
+	tests := []struct {
+		name    string
+		bbox    string
+		crs     string
+		wantErr bool
+	}{
+		{name: "Oslo", bbox: "261000,6647000,263000,6650000", crs: "EPSG:25833"},
+		{name: "EPSG:4326 is latitude first", bbox: "59.9,10.7,60.0,10.8", crs: "EPSG:4326"},
+		{name: "outside Norway is an empty map", bbox: "-80,0,-70,1", crs: "CRS:84"},
+		{name: "can not be transformed", bbox: "-75,0,10,60", crs: "CRS:84", wantErr: true},
+		{name: "not finite", bbox: "261000,6647000,263000,NaN", crs: "EPSG:25833", wantErr: true},
+		{name: "min not less than max", bbox: "263000,6647000,261000,6650000", crs: "EPSG:25833", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]string{"layers": "forestryroads", "crs": tt.crs, "bbox": tt.bbox, "width": "256", "height": "256"}
+			_, err := parseWMSMapRequest(params)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseWMSMapRequest(%v) returned %v, want error %v", params, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetWMSRoadsOutsideNorway(t *testing.T) {
+	params := map[string]string{"layers": "forestryroads", "crs": "CRS:84", "bbox": "-80,0,-70,1", "width": "256", "height": "256"}
+	mapRequest, err := parseWMSMapRequest(params)
+	if err != nil {
+		t.Fatalf("parseWMSMapRequest(%v) returned %v", params, err)
+	}
+
+	// No roads are looked up for a map outside Norway
+	response, ok := getWMSRoads(httptest.NewRecorder(), httptest.NewRequest("GET", "/wms", nil), mapRequest)
+	if !ok || len(response.Features) != 0 {
+		t.Errorf("getWMSRoads() = %d roads, %v, want no roads", len(response.Features), ok)
+	}
+}
@@ -1,10 +1,18 @@
 package server
 
 import (
+	"fmt"
 	"net/http"
+	"os"
 	"skogkursbachelor/server/internal/constants"
 	"skogkursbachelor/server/internal/http/handlers"
+	"skogkursbachelor/server/internal/http/upstream"
+	"skogkursbachelor/server/internal/services/roadstore"
+	"skogkursbachelor/server/internal/services/senorge"
+	"skogkursbachelor/server/internal/services/superficialdeposits"
+	"skogkursbachelor/server/internal/services/trafficability"
 	"skogkursbachelor/server/internal/utils"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
@@ -23,6 +31,23 @@ func Start() {
 		log.Fatal().Msg("Error loading proxies: " + err.Error())
 	}
 
+	// Load the config files, and open the stores
+	err = LoadConfig()
+	if err != nil {
+		log.Fatal().Msg("Error loading configuration: " + err.Error())
+	}
+
+	// Read the superficial deposit shapefiles now, so the first request is not slowed by it
+	superficialdeposits.LoadIndexes()
+
+	// Serve forestry roads from the local store instead of the GeoNorge WFS, see the import-roads command
+	if os.Getenv("FORESTRY_ROADS_SOURCE") == "local" {
+		err = roadstore.InitStore(os.Getenv("FORESTRY_ROADS_DATA_DIR"))
+		if err != nil {
+			log.Fatal().Msg("Error opening forestry road store: " + err.Error())
+		}
+	}
+
 	for path, remoteAddr := range proxies {
 		log.Info().Msg(path + "->" + remoteAddr)
 		p := &handlers.Proxy{RemoteAddr: remoteAddr}
@@ -36,9 +61,71 @@ func Start() {
 	// Forestry roads
 	mux.HandleFunc(constants.ForestryRoadsPath, handlers.ForestryRoadsHandler)
 
+	// Forestry roads as vector tiles, the last segment is "{y}.mvt"
+	mux.HandleFunc(constants.ForestryRoadTilesPath+"/{z}/{x}/{y}", handlers.ForestryRoadTilesHandler)
+
+	// Forestry roads as a WMS 1.3.0 layer, for GIS clients
+	mux.HandleFunc(constants.WMSPath, handlers.WMSHandler)
+
+	// Forestry roads as an OGC API Features collection, for generic tooling
+	mux.HandleFunc(constants.APIPath+"{$}", handlers.OGCLandingPageHandler)
+	mux.HandleFunc(constants.ConformancePath, handlers.OGCConformanceHandler)
+	mux.HandleFunc(constants.CollectionsPath, handlers.OGCCollectionsHandler)
+	mux.HandleFunc(constants.CollectionsPath+"/{collectionId}", handlers.OGCCollectionHandler)
+	mux.HandleFunc(constants.CollectionsPath+"/{collectionId}/items", handlers.OGCItemsHandler)
+	mux.HandleFunc(constants.CollectionsPath+"/{collectionId}/items/{featureId}", handlers.OGCItemHandler)
+
 	// Forestry roads legend
 	mux.HandleFunc(constants.ForestLegendPath, handlers.ForestryLegendHandler)
 
 	log.Info().Msg("Starting server on port " + port + " ...")
 	log.Fatal().Msg(http.ListenAndServe(":"+port, mux).Error())
 }
+
+// LoadConfig loads the config files, and opens the stores, configured by environment variables.
+// It is used by the server and the commands that enrich roads.
+func LoadConfig() error {
+	// Load timeouts, retries and circuit breakers for upstream hosts, see upstreams.json
+	err := upstream.LoadConfigFromFile()
+	if err != nil {
+		return fmt.Errorf("failed to load upstream config: %v", err)
+	}
+
+	// Load extra SeNorge themes, see senorge.json
+	err = senorge.LoadThemesFromFile()
+	if err != nil {
+		return fmt.Errorf("failed to load SeNorge themes: %v", err)
+	}
+
+	// Cache SeNorge values, size is the number of values, one per theme, date and grid cell
+	senorge.InitCache(
+		utils.GetEnvInt("SENORGE_CACHE_SIZE", 500000),
+		utils.GetEnvDuration("SENORGE_CACHE_TODAY_TTL", 15*time.Minute),
+	)
+
+	// Split large SeNorge requests into chunks of grid points
+	senorge.ConfigureChunks(
+		utils.GetEnvInt("SENORGE_CHUNK_SIZE", 500),
+		utils.GetEnvInt("SENORGE_CHUNK_CONCURRENCY", 4),
+	)
+
+	// Keep SeNorge values for past dates on disk, so they survive restarts
+	err = senorge.InitStore(os.Getenv("SENORGE_DATA_DIR"))
+	if err != nil {
+		return fmt.Errorf("failed to open SeNorge store: %v", err)
+	}
+
+	// Read superficial deposits precomputed by the precompute-deposits command, if there are any
+	err = superficialdeposits.InitStore(os.Getenv("DEPOSITS_DATA_DIR"))
+	if err != nil {
+		return fmt.Errorf("failed to open precomputed superficial deposits: %v", err)
+	}
+
+	// Load trafficability rules, see trafficability.json
+	err = trafficability.LoadRulesFromFile()
+	if err != nil {
+		return fmt.Errorf("failed to load trafficability rules: %v", err)
+	}
+
+	return nil
+}
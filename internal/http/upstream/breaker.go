@@ -0,0 +1,73 @@
+package upstream
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the upstream when its circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open, upstream is failing")
+
+// breaker is a circuit breaker. It opens after failureThreshold failed calls in a row, and fails fast until the
+// cooldown has passed. Then one trial call is let through, which closes the breaker if it succeeds.
+type breaker struct {
+	mu               sync.Mutex
+	failureThreshold int
+	cooldown         time.Duration
+	failures         int
+	openedAt         time.Time
+	trialInFlight    bool
+}
+
+// allow checks if a call may be made, and reserves the trial call when the cooldown has passed.
+// The second result is true for the trial call, and is passed on to release or record.
+func (b *breaker) allow() (bool, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Closed, or disabled
+	if b.failureThreshold <= 0 || b.failures < b.failureThreshold {
+		return true, false
+	}
+
+	// Open
+	if time.Since(b.openedAt) < b.cooldown || b.trialInFlight {
+		return false, false
+	}
+
+	// Half open, let one trial call through
+	b.trialInFlight = true
+	return true, true
+}
+
+// release gives back a call that was allowed, without recording a result.
+// Only the trial call frees the trial, calls allowed before the breaker opened do not.
+func (b *breaker) release(trial bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if trial {
+		b.trialInFlight = false
+	}
+}
+
+// record records the result of a call that was allowed.
+func (b *breaker) record(success, trial bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if trial {
+		b.trialInFlight = false
+	}
+
+	if success {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.failureThreshold {
+		b.openedAt = time.Now()
+	}
+}
@@ -0,0 +1,41 @@
+package upstream
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreakerTrial(t *testing.T) {
+	b := &breaker{failureThreshold: 1, cooldown: time.Millisecond}
+
+	// A call allowed while the breaker is closed, still running when the breaker opens
+	allowed, slowTrial := b.allow()
+	if !allowed || slowTrial {
+		t.Fatalf("allow() on a closed breaker = %v, %v, want true, false", allowed, slowTrial)
+	}
+
+	_, trial := b.allow()
+	b.record(false, trial)
+	time.Sleep(2 * time.Millisecond)
+
+	allowed, trial = b.allow()
+	if !allowed || !trial {
+		t.Fatalf("allow() after the cooldown = %v, %v, want true, true", allowed, trial)
+	}
+
+	// The slow call must not free the trial
+	b.release(slowTrial)
+	if allowed, _ := b.allow(); allowed {
+		t.Errorf("allow() while the trial is in flight = true, want false")
+	}
+	b.record(false, slowTrial)
+	time.Sleep(2 * time.Millisecond)
+	if allowed, _ := b.allow(); allowed {
+		t.Errorf("allow() after a non-trial result = true, want false while the trial is in flight")
+	}
+
+	b.record(true, trial)
+	if allowed, trial := b.allow(); !allowed || trial {
+		t.Errorf("allow() after a successful trial = %v, %v, want true, false", allowed, trial)
+	}
+}
@@ -0,0 +1,239 @@
+// Package upstream is the shared HTTP client for outbound calls, with a timeout, retries with exponential backoff
+// and a circuit breaker per upstream host. See upstreams.json
+package upstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// _configFile is the file the upstream configuration is loaded from. See upstreams.json
+const _configFile = "upstreams.json"
+
+// _defaultName is the configuration used for hosts that are not configured
+const _defaultName = "default"
+
+// Config is the configuration of an upstream host.
+type Config struct {
+	// Timeout of a single attempt, including reading the response body
+	Timeout Duration `json:"timeout"`
+	// Retries is how many times a failed idempotent request is retried
+	Retries int `json:"retries"`
+	// Backoff is the wait before the first retry, doubled for each retry up to MaxBackoff
+	Backoff    Duration `json:"backoff"`
+	MaxBackoff Duration `json:"maxBackoff"`
+	// RetryNonIdempotent allows retrying POST requests, for upstreams where POST is only used for queries
+	RetryNonIdempotent bool `json:"retryNonIdempotent"`
+	// FailureThreshold is how many failed calls in a row open the circuit breaker, 0 disables it
+	FailureThreshold int `json:"failureThreshold"`
+	// Cooldown is how long the circuit breaker stays open before a trial call is let through
+	Cooldown Duration `json:"cooldown"`
+}
+
+// Duration is a time.Duration that is read from JSON as a string, e.g. "30s"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// client is the HTTP client of an upstream host.
+type client struct {
+	host    string
+	config  Config
+	http    *http.Client
+	breaker *breaker
+}
+
+var (
+	_mu      sync.Mutex
+	_configs = map[string]Config{
+		_defaultName: {
+			Timeout:          Duration(30 * time.Second),
+			Retries:          2,
+			Backoff:          Duration(200 * time.Millisecond),
+			MaxBackoff:       Duration(2 * time.Second),
+			FailureThreshold: 5,
+			Cooldown:         Duration(30 * time.Second),
+		},
+	}
+	_clients = make(map[string]*client)
+)
+
+// LoadConfigFromFile loads the upstream configuration, keyed by host. Hosts inherit unset values from "default".
+func LoadConfigFromFile() error {
+	data, err := os.ReadFile(_configFile)
+	if err != nil {
+		return err
+	}
+
+	var raw map[string]json.RawMessage
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	_mu.Lock()
+	defer _mu.Unlock()
+
+	defaultConfig := _configs[_defaultName]
+	if rawDefault, ok := raw[_defaultName]; ok {
+		err = json.Unmarshal(rawDefault, &defaultConfig)
+		if err != nil {
+			return fmt.Errorf("%s: %v", _defaultName, err)
+		}
+	}
+
+	configs := map[string]Config{_defaultName: defaultConfig}
+	for host, rawConfig := range raw {
+		if host == _defaultName {
+			continue
+		}
+
+		config := defaultConfig
+		err = json.Unmarshal(rawConfig, &config)
+		if err != nil {
+			return fmt.Errorf("%s: %v", host, err)
+		}
+		configs[host] = config
+	}
+
+	_configs = configs
+	_clients = make(map[string]*client)
+	return nil
+}
+
+// getClient returns the client for the host, creating it on first use.
+func getClient(host string) *client {
+	_mu.Lock()
+	defer _mu.Unlock()
+
+	if c, ok := _clients[host]; ok {
+		return c
+	}
+
+	config, ok := _configs[host]
+	if !ok {
+		config = _configs[_defaultName]
+	}
+
+	c := &client{
+		host:   host,
+		config: config,
+		http:   &http.Client{Timeout: time.Duration(config.Timeout)},
+		breaker: &breaker{
+			failureThreshold: config.FailureThreshold,
+			cooldown:         time.Duration(config.Cooldown),
+		},
+	}
+	_clients[host] = c
+	return c
+}
+
+// Do sends the request with the client of its host. Idempotent requests that fail with a network error or
+// a 429 or 5xx status are retried with exponential backoff. When the circuit breaker of the host is open,
+// ErrCircuitOpen is returned without sending the request.
+func Do(req *http.Request) (*http.Response, error) {
+	c := getClient(req.URL.Host)
+
+	allowed, trial := c.breaker.allow()
+	if !allowed {
+		return nil, fmt.Errorf("%s: %w", c.host, ErrCircuitOpen)
+	}
+
+	resp, err := c.do(req)
+
+	// An abandoned request says nothing about the upstream
+	if req.Context().Err() != nil {
+		c.breaker.release(trial)
+		return resp, err
+	}
+
+	c.breaker.record(err == nil && resp.StatusCode < http.StatusInternalServerError, trial)
+	return resp, err
+}
+
+// do sends the request, and retries it if it is allowed.
+func (c *client) do(req *http.Request) (*http.Response, error) {
+	retries := 0
+	if c.canRetry(req) {
+		retries = c.config.Retries
+	}
+
+	backoff := time.Duration(c.config.Backoff)
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind request body: %v", err)
+			}
+			req.Body = body
+		}
+
+		resp, err := c.http.Do(req)
+		if attempt >= retries || !shouldRetry(resp, err) || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+			log.Warn().Msgf("Retrying %s %s after status %s", req.Method, c.host, resp.Status)
+		} else {
+			log.Warn().Msgf("Retrying %s %s after error: %s", req.Method, c.host, err.Error())
+		}
+
+		// Full jitter, so that clients do not retry in lockstep
+		wait := time.Duration(rand.Int64N(int64(backoff) + 1))
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+
+		backoff = min(2*backoff, time.Duration(c.config.MaxBackoff))
+	}
+}
+
+// canRetry checks if the request is idempotent, and its body can be sent again.
+func (c *client) canRetry(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	case http.MethodPost:
+		return c.config.RetryNonIdempotent
+	default:
+		return false
+	}
+}
+
+// shouldRetry checks if the attempt failed in a way that another attempt may fix.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
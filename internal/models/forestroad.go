@@ -1,9 +1,12 @@
 package models
 
 import (
+	"encoding/json"
 	"fmt"
+	"reflect"
 	"runtime"
 	"skogkursbachelor/server/internal/utils"
+	"strings"
 	"sync"
 )
 
@@ -19,33 +22,164 @@ type WFSResponse struct {
 			Name string `json:"name"`
 		} `json:"properties"`
 	} `json:"crs"`
-	Date     string       `json:"date"`
-	Features []ForestRoad `json:"features"`
+	Date     string            `json:"date"`
+	Enheter  map[string]string `json:"enheter,omitempty"`
+	Warnings []Warning         `json:"warnings,omitempty"`
+	Features []ForestRoad      `json:"features"`
+}
+
+// Warning describes a data source that failed, the response is still returned with the failed properties set to null.
+type Warning struct {
+	Source  string `json:"source"`
+	Message string `json:"message"`
 }
 
 // ForestRoad represents a forest road feature with its properties and geometry.
 type ForestRoad struct {
-	Type       string `json:"type"`
-	Properties struct {
-		Kommunenummer           string  `json:"kommunenummer"`
-		Vegkategori             string  `json:"vegkategori"`
-		Vegfase                 string  `json:"vegfase"`
-		Vegnummer               string  `json:"vegnummer"`
-		Strekningnummer         string  `json:"strekningnummer"`
-		Delstrekningnummer      string  `json:"delstrekningnummer"`
-		Frameter                string  `json:"frameter"`
-		Tilmeter                string  `json:"tilmeter"`
-		Teledybde               float64 `json:"teledybde"`
-		Vannmetning             float64 `json:"vannmetning"`
-		Løsmassekoder           []int   `json:"løsmassekoder"`
-		Erklyngesenterundervann bool    `json:"erklyngesenterundervann"`
-	} `json:"properties"`
-	Geometry struct {
+	Type string `json:"type"`
+	// ID is only set by endpoints that serve single roads, see forestryroads.SetFeatureIDs
+	ID         string               `json:"id,omitempty"`
+	Properties ForestRoadProperties `json:"properties"`
+	Geometry   struct {
 		Type        string      `json:"type"`
 		Coordinates [][]float64 `json:"coordinates"`
 	} `json:"geometry"`
 }
 
+// ForestRoadProperties are the properties of a forest road, from the WFS and from enrichment.
+type ForestRoadProperties struct {
+	Kommunenummer               string           `json:"kommunenummer"`
+	Vegkategori                 string           `json:"vegkategori"`
+	Vegfase                     string           `json:"vegfase"`
+	Vegnummer                   string           `json:"vegnummer"`
+	Strekningnummer             string           `json:"strekningnummer"`
+	Delstrekningnummer          string           `json:"delstrekningnummer"`
+	Frameter                    string           `json:"frameter"`
+	Tilmeter                    string           `json:"tilmeter"`
+	Teledybde                   *float64         `json:"teledybde"`
+	Vannmetning                 *float64         `json:"vannmetning"`
+	Snødybde                    *float64         `json:"snødybde"`
+	Snøvannekvivalent           *float64         `json:"snøvannekvivalent"`
+	Teledybdeerprognose         bool             `json:"teledybdeerprognose"`
+	Vannmetningerprognose       bool             `json:"vannmetningerprognose"`
+	Snødybdeerprognose          bool             `json:"snødybdeerprognose"`
+	Snøvannekvivalenterprognose bool             `json:"snøvannekvivalenterprognose"`
+	Teledybdeserie              []DailyValue     `json:"teledybdeserie,omitempty"`
+	Vannmetningserie            []DailyValue     `json:"vannmetningserie,omitempty"`
+	Snødybdeserie               []DailyValue     `json:"snødybdeserie,omitempty"`
+	Snøvannekvivalentserie      []DailyValue     `json:"snøvannekvivalentserie,omitempty"`
+	Løsmassekoder               []int            `json:"løsmassekoder"`
+	Løsmassesegmenter           []DepositSegment `json:"løsmassesegmenter"`
+	Erklyngesenterundervann     bool             `json:"erklyngesenterundervann"`
+	Framkommelighetsklasse      string           `json:"framkommelighetsklasse"`
+	Framkommelighetsscore       float64          `json:"framkommelighetsscore"`
+	Framkommelighetsgrunner     []string         `json:"framkommelighetsgrunner"`
+	// Datastatus tells how each SeNorge property was found, or why it is null, keyed by property
+	Datastatus map[string]string `json:"datastatus"`
+
+	// Ekstra holds properties from configured SeNorge themes, they are encoded next to the other properties
+	Ekstra map[string]interface{} `json:"-"`
+}
+
+// MarshalJSON encodes the properties, with the extra properties added to the same object.
+func (properties ForestRoadProperties) MarshalJSON() ([]byte, error) {
+	// The alias has no MarshalJSON method, avoiding infinite recursion
+	type alias ForestRoadProperties
+	data, err := json.Marshal(alias(properties))
+	if err != nil || len(properties.Ekstra) == 0 {
+		return data, err
+	}
+
+	extra, err := json.Marshal(properties.Ekstra)
+	if err != nil {
+		return nil, err
+	}
+
+	// Merge the objects, {"a":1} and {"b":2} -> {"a":1,"b":2}
+	data = append(data[:len(data)-1], ',')
+	return append(data, extra[1:]...), nil
+}
+
+// SetDataStatus sets the data status of a SeNorge property.
+func (properties *ForestRoadProperties) SetDataStatus(key string, status string) {
+	if properties.Datastatus == nil {
+		properties.Datastatus = make(map[string]string)
+	}
+	properties.Datastatus[key] = status
+}
+
+// SetEkstra sets an extra property.
+func (properties *ForestRoadProperties) SetEkstra(key string, value interface{}) {
+	if properties.Ekstra == nil {
+		properties.Ekstra = make(map[string]interface{})
+	}
+	properties.Ekstra[key] = value
+}
+
+// DominantDeposit returns a segment of the superficial deposit the longest part of the road is on.
+// Returns false if the road has no deposit segments.
+func (properties ForestRoadProperties) DominantDeposit() (DepositSegment, bool) {
+	if len(properties.Løsmassesegmenter) == 0 {
+		return DepositSegment{}, false
+	}
+
+	lengths := make(map[int]float64)
+	dominant := properties.Løsmassesegmenter[0]
+	for _, segment := range properties.Løsmassesegmenter {
+		lengths[segment.Kode] += segment.TilMeter - segment.FraMeter
+		if lengths[segment.Kode] > lengths[dominant.Kode] {
+			dominant = segment
+		}
+	}
+	return dominant, true
+}
+
+// DailyValue is the value of a SeNorge theme on a given date, formatted as YYYY-MM-DD.
+// Verdi is nil when the grid cell has no data on the date.
+// Prognose is true when the value is from the SeNorge forecast, and false when it is observed.
+type DailyValue struct {
+	Dato     string   `json:"dato"`
+	Verdi    *float64 `json:"verdi"`
+	Prognose bool     `json:"prognose"`
+}
+
+// DepositSegment is a part of a forest road that lies on a single superficial deposit type.
+// FraMeter and TilMeter are measured in meters along the road geometry, from its first coordinate.
+type DepositSegment struct {
+	FraMeter float64 `json:"frameter"`
+	TilMeter float64 `json:"tilmeter"`
+	Kode     int     `json:"kode"`
+	Navn     string  `json:"navn"`
+}
+
+// ClusterKey returns the 1000x1000 meter square the middle coordinate of the road is in, as "X,Y" of its centre.
+func ClusterKey(road ForestRoad) string {
+	middleIndex := len(road.Geometry.Coordinates) / 2
+	coordinates := road.Geometry.Coordinates[middleIndex]
+
+	roundedX := utils.RoundToNearest500(coordinates[0])
+	roundedY := utils.RoundToNearest500(coordinates[1])
+	return fmt.Sprintf("%d,%d", roundedX, roundedY)
+}
+
+// IsForestRoadProperty checks if the key is the JSON name of a property of ForestRoadProperties.
+func IsForestRoadProperty(key string) bool {
+	return _forestRoadPropertyNames[key]
+}
+
+// _forestRoadPropertyNames are the JSON names of the properties of ForestRoadProperties
+var _forestRoadPropertyNames = func() map[string]bool {
+	names := make(map[string]bool)
+	properties := reflect.TypeOf(ForestRoadProperties{})
+	for i := 0; i < properties.NumField(); i++ {
+		name := strings.Split(properties.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}()
+
 // ClusterWFSResponseToShardedMap processes the features from the WFS response and clusters them into 1000x1000 meter squares.
 // Returns a sharded map with the features clustered by coordinates.
 func (wfsResponse WFSResponse) ClusterWFSResponseToShardedMap() *ShardedMap {
@@ -61,14 +195,7 @@ func (wfsResponse WFSResponse) ClusterWFSResponseToShardedMap() *ShardedMap {
 			defer wg.Done()
 			defer func() { <-semaphore }()
 
-			middleIndex := len(feature.Geometry.Coordinates) / 2
-			coordinates := feature.Geometry.Coordinates[middleIndex]
-
-			roundedX := utils.RoundToNearest500(coordinates[0])
-			roundedY := utils.RoundToNearest500(coordinates[1])
-			roundedCoordinates := fmt.Sprintf("%d,%d", roundedX, roundedY)
-
-			featureMap.Set(roundedCoordinates, feature)
+			featureMap.Set(ClusterKey(feature), feature)
 		}(feature)
 	}
 
@@ -0,0 +1,65 @@
+package models
+
+// Link is a link of an OGC API resource.
+type Link struct {
+	Href  string `json:"href"`
+	Rel   string `json:"rel"`
+	Type  string `json:"type,omitempty"`
+	Title string `json:"title,omitempty"`
+}
+
+// LandingPage is the landing page of the OGC API.
+type LandingPage struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Links       []Link `json:"links"`
+}
+
+// Conformance lists the OGC API conformance classes the server implements.
+type Conformance struct {
+	ConformsTo []string `json:"conformsTo"`
+}
+
+// Collections lists the collections of the OGC API.
+type Collections struct {
+	Links       []Link       `json:"links"`
+	Collections []Collection `json:"collections"`
+}
+
+// Collection describes a collection of features.
+type Collection struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Links       []Link `json:"links"`
+	Extent      struct {
+		Spatial struct {
+			Bbox [][]float64 `json:"bbox"`
+			Crs  string      `json:"crs"`
+		} `json:"spatial"`
+		Temporal struct {
+			Interval [][]*string `json:"interval"`
+		} `json:"temporal"`
+	} `json:"extent"`
+	ItemType   string   `json:"itemType"`
+	Crs        []string `json:"crs"`
+	StorageCrs string   `json:"storageCrs"`
+}
+
+// FeatureCollection is a page of the items of a collection.
+type FeatureCollection struct {
+	Type           string            `json:"type"`
+	NumberMatched  int               `json:"numberMatched"`
+	NumberReturned int               `json:"numberReturned"`
+	TimeStamp      string            `json:"timeStamp"`
+	Enheter        map[string]string `json:"enheter,omitempty"`
+	Warnings       []Warning         `json:"warnings,omitempty"`
+	Links          []Link            `json:"links"`
+	Features       []ForestRoad      `json:"features"`
+}
+
+// OGCException is the error body of the OGC API.
+type OGCException struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
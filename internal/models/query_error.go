@@ -0,0 +1,14 @@
+package models
+
+// QueryError is the error body of a request with invalid URL parameters, with every invalid parameter.
+type QueryError struct {
+	Message    string             `json:"message"`
+	Parameters []InvalidParameter `json:"parameters"`
+}
+
+// InvalidParameter is a URL parameter that is invalid, and why.
+type InvalidParameter struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	Message string `json:"message"`
+}
@@ -1,5 +1,23 @@
 package models
 
+import "strings"
+
+// Data status of SeNorge properties on a forest road
+const (
+	// DataStatusOK means the value is from the grid cell the road is clustered in
+	DataStatusOK = "ok"
+	// DataStatusNoData means the grid cell has no data, e.g. outside the SeNorge grid
+	DataStatusNoData = "nodata"
+	// DataStatusUnderwater means the cluster centre is in a fjord, and was not requested
+	DataStatusUnderwater = "underwater"
+	// DataStatusMissing means the grid cell was not in the response
+	DataStatusMissing = "missing"
+	// DataStatusFailed means the request for the theme failed
+	DataStatusFailed = "failed"
+	// DataStatusInterpolated means the value is weighted from the neighbouring grid cells
+	DataStatusInterpolated = "interpolated"
+)
+
 // NVEFMultiPointTimeSeriesRequest represents the request structure for NVEF MultiPoint Time Series.
 type NVEFMultiPointTimeSeriesRequest struct {
 	Theme            string `json:"Theme"`
@@ -11,19 +29,36 @@ type NVEFMultiPointTimeSeriesRequest struct {
 
 // NVEMultiPointTimeSeriesResponse represents the response structure for NVE MultiPoint Time Series.
 type NVEMultiPointTimeSeriesResponse struct {
-	CellTimeSeries    []cellTimeSeries `json:"CellTimeSeries"`
+	CellTimeSeries    []CellTimeSeries `json:"CellTimeSeries"`
 	Theme             string           `json:"Theme"`
 	FullName          interface{}      `json:"FullName"`
 	NoDataValue       int              `json:"NoDataValue"`
 	StartDate         string           `json:"StartDate"`
 	EndDate           string           `json:"EndDate"`
-	PrognoseStartDate interface{}      `json:"PrognoseStartDate"`
+	PrognoseStartDate string           `json:"PrognoseStartDate"`
 	Unit              string           `json:"Unit"`
 	TimeResolution    int              `json:"TimeResolution"`
 }
 
-// cellTimeSeries represents the time series data for a specific cell in the NVE response.
-type cellTimeSeries struct {
+// IsPrognose checks if the value on the date, formatted as YYYY-MM-DD, is from the forecast part of the series.
+// The forecast starts on PrognoseStartDate, which is empty when the series has no forecast.
+func (response NVEMultiPointTimeSeriesResponse) IsPrognose(date string) bool {
+	prognoseStartDate := strings.Split(response.PrognoseStartDate, "T")[0]
+	if prognoseStartDate == "" {
+		return false
+	}
+
+	// Dates formatted as YYYY-MM-DD sort lexically
+	return date >= prognoseStartDate
+}
+
+// IsNoData checks if a value is the no-data value of the response.
+func (response NVEMultiPointTimeSeriesResponse) IsNoData(value float64) bool {
+	return value == float64(response.NoDataValue)
+}
+
+// CellTimeSeries represents the time series data for a specific cell in the NVE response.
+type CellTimeSeries struct {
 	X         int       `json:"X"`
 	Y         int       `json:"Y"`
 	Altitude  int       `json:"Altitude"`
@@ -13,25 +13,45 @@ import (
 // SpatialIndex represents an R-tree for efficient spatial queries
 type SpatialIndex struct {
 	tree rtree.RTree
-	data map[string]interface{}
+	data map[string]indexEntry
 	mu   sync.RWMutex
 }
 
+// indexEntry is a geometry in the spatial index and its attributes
+type indexEntry struct {
+	geometry   geom.T
+	attributes interface{}
+}
+
 // NewSpatialIndex creates a new spatial index
 func NewSpatialIndex() *SpatialIndex {
 	return &SpatialIndex{
 		tree: rtree.RTree{},
-		data: make(map[string]interface{}),
+		data: make(map[string]indexEntry),
 	}
 }
 
-// Insert adds a geometry and its attributes to the spatial index
-func (si *SpatialIndex) Insert(minX, minY, maxX, maxY float64, key string, value interface{}) {
-	si.tree.Insert([2]float64{minX, minY}, [2]float64{maxX, maxY}, key)
-	si.data[key] = value
+// Len returns the number of geometries in the spatial index.
+func (si *SpatialIndex) Len() int {
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+
+	return len(si.data)
+}
+
+// Insert adds a geometry and its attributes to the spatial index.
+// The geometry is indexed by its bounding box, and kept for exact point queries.
+func (si *SpatialIndex) Insert(geometry geom.T, key string, value interface{}) {
+	bbox := geometry.Bounds()
+
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	si.tree.Insert([2]float64{bbox.Min(0), bbox.Min(1)}, [2]float64{bbox.Max(0), bbox.Max(1)}, key)
+	si.data[key] = indexEntry{geometry: geometry, attributes: value}
 }
 
-// Query finds all geometries that intersect with the given bounding box
+// Query finds all geometries whose bounding box intersects with the given bounding box
 func (si *SpatialIndex) Query(minX, minY, maxX, maxY float64) []interface{} {
 	si.mu.RLock()
 	defer si.mu.RUnlock()
@@ -44,7 +64,35 @@ func (si *SpatialIndex) Query(minX, minY, maxX, maxY float64) []interface{} {
 		func(min, max [2]float64, data interface{}) bool {
 			// Append the attributes associated with the key
 			if key, ok := data.(string); ok {
-				results = append(results, si.data[key])
+				results = append(results, si.data[key].attributes)
+			}
+			return true // Continue searching
+		},
+	)
+
+	return results
+}
+
+// QueryPoint finds all polygons that contain the given point.
+// Candidates are found by bounding box, and then refined with a point-in-polygon test that respects holes.
+func (si *SpatialIndex) QueryPoint(x, y float64) []interface{} {
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+
+	var results []interface{}
+
+	si.tree.Search(
+		[2]float64{x, y},
+		[2]float64{x, y},
+		func(min, max [2]float64, data interface{}) bool {
+			key, ok := data.(string)
+			if !ok {
+				return true
+			}
+
+			entry := si.data[key]
+			if geometryContainsPoint(entry.geometry, x, y) {
+				results = append(results, entry.attributes)
 			}
 			return true // Continue searching
 		},
@@ -74,22 +122,17 @@ func ReadShapeFilesAndBuildIndex(shapefiles []string) *SpatialIndex {
 				attributes, geometry := sf.Record(i)
 				switch g := geometry.(type) {
 				case *geom.Polygon:
-					bbox := g.Bounds()
 					key := fmt.Sprintf("%s_%d", f, i)
-					index.Insert(bbox.Min(0), bbox.Min(1), bbox.Max(0), bbox.Max(1), key, attributes)
+					index.Insert(g, key, attributes)
 				case *geom.MultiPolygon:
 					for j := 0; j < g.NumPolygons(); j++ {
-						polygon := g.Polygon(j)
-						bbox := polygon.Bounds()
 						key := fmt.Sprintf("%s_%d_%d", f, i, j)
-						index.Insert(bbox.Min(0), bbox.Min(1), bbox.Max(0), bbox.Max(1), key, attributes)
+						index.Insert(g.Polygon(j), key, attributes)
 					}
 				case *geom.MultiLineString:
 					for j := 0; j < g.NumLineStrings(); j++ {
-						line := g.LineString(j)
-						bbox := line.Bounds()
 						key := fmt.Sprintf("%s_%d_%d", f, i, j)
-						index.Insert(bbox.Min(0), bbox.Min(1), bbox.Max(0), bbox.Max(1), key, attributes)
+						index.Insert(g.LineString(j), key, attributes)
 					}
 				default:
 					log.Debug().Msgf("Unsupported geometry type in %s: %T", f, geometry)
@@ -102,9 +145,9 @@ func ReadShapeFilesAndBuildIndex(shapefiles []string) *SpatialIndex {
 	return index
 }
 
-// QuerySpatialIndex checks if a point is inside any geometry in the spatial index
+// QuerySpatialIndex returns the attributes of every polygon in the spatial index that contains the point
 func QuerySpatialIndex(index *SpatialIndex, x, y float64) ([]map[string]interface{}, error) {
-	results := index.Query(x, y, x, y)
+	results := index.QueryPoint(x, y)
 
 	var attributesList []map[string]interface{}
 
@@ -118,3 +161,59 @@ func QuerySpatialIndex(index *SpatialIndex, x, y float64) ([]map[string]interfac
 
 	return attributesList, nil
 }
+
+// geometryContainsPoint checks if a polygon or multipolygon contains the point.
+// Lines have no area, and never contain a point.
+func geometryContainsPoint(geometry geom.T, x, y float64) bool {
+	switch g := geometry.(type) {
+	case *geom.Polygon:
+		return polygonContainsPoint(g, x, y)
+	case *geom.MultiPolygon:
+		for i := 0; i < g.NumPolygons(); i++ {
+			if polygonContainsPoint(g.Polygon(i), x, y) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// polygonContainsPoint checks if the point is inside the exterior ring of the polygon, and outside all of its holes.
+func polygonContainsPoint(polygon *geom.Polygon, x, y float64) bool {
+	if polygon.NumLinearRings() == 0 {
+		return false
+	}
+
+	if !ringContainsPoint(polygon.LinearRing(0), x, y) {
+		return false
+	}
+
+	for i := 1; i < polygon.NumLinearRings(); i++ {
+		if ringContainsPoint(polygon.LinearRing(i), x, y) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ringContainsPoint uses the even-odd rule, casting a ray from the point towards positive x and counting crossings.
+func ringContainsPoint(ring *geom.LinearRing, x, y float64) bool {
+	flatCoords := ring.FlatCoords()
+	stride := ring.Stride()
+	n := len(flatCoords) / stride
+
+	inside := false
+	for i, j := 0, n-1; i < n; j, i = i, i+1 {
+		xi, yi := flatCoords[i*stride], flatCoords[i*stride+1]
+		xj, yj := flatCoords[j*stride], flatCoords[j*stride+1]
+
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+
+	return inside
+}
@@ -0,0 +1,192 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// testPolygon is a shapefile polygon record, rings are closed lists of x, y pairs.
+// Exterior rings are clockwise and holes counter-clockwise, as in the shapefile specification.
+type testPolygon struct {
+	jordart int
+	rings   [][][2]float64
+}
+
+// writeTestShapefile writes a minimal .shp and .dbf with a numeric jordart field, and returns its basename.
+func writeTestShapefile(t *testing.T, polygons []testPolygon) string {
+	t.Helper()
+
+	basename := filepath.Join(t.TempDir(), "test")
+
+	var records bytes.Buffer
+	minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for i, polygon := range polygons {
+		var content bytes.Buffer
+		var parts []int32
+		var points [][2]float64
+		for _, ring := range polygon.rings {
+			parts = append(parts, int32(len(points)))
+			points = append(points, ring...)
+		}
+
+		pMinX, pMinY, pMaxX, pMaxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+		for _, p := range points {
+			pMinX, pMinY = math.Min(pMinX, p[0]), math.Min(pMinY, p[1])
+			pMaxX, pMaxY = math.Max(pMaxX, p[0]), math.Max(pMaxY, p[1])
+		}
+		minX, minY = math.Min(minX, pMinX), math.Min(minY, pMinY)
+		maxX, maxY = math.Max(maxX, pMaxX), math.Max(maxY, pMaxY)
+
+		writeLE(&content, int32(5), pMinX, pMinY, pMaxX, pMaxY, int32(len(parts)), int32(len(points)), parts, points)
+
+		writeBE(&records, int32(i+1), int32(content.Len()/2))
+		records.Write(content.Bytes())
+	}
+
+	var shp bytes.Buffer
+	writeBE(&shp, int32(9994), [5]int32{}, int32((100+records.Len())/2))
+	writeLE(&shp, int32(1000), int32(5), minX, minY, maxX, maxY, [4]float64{})
+	shp.Write(records.Bytes())
+
+	if err := os.WriteFile(basename+".shp", shp.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	const fieldLength = 4
+	var dbf bytes.Buffer
+	writeLE(&dbf, byte(3), [3]byte{125, 1, 1}, int32(len(polygons)), int16(32+32+1), int16(1+fieldLength), [20]byte{})
+	var name [11]byte
+	copy(name[:], "jordart")
+	writeLE(&dbf, name, byte('N'), [4]byte{}, byte(fieldLength), byte(0), [14]byte{})
+	dbf.WriteByte(0x0d)
+	for _, polygon := range polygons {
+		dbf.WriteString(fmt.Sprintf(" %*d", fieldLength, polygon.jordart))
+	}
+	dbf.WriteByte(0x1a)
+
+	if err := os.WriteFile(basename+".dbf", dbf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return basename
+}
+
+func writeLE(buf *bytes.Buffer, values ...interface{}) {
+	for _, v := range values {
+		_ = binary.Write(buf, binary.LittleEndian, v)
+	}
+}
+
+func writeBE(buf *bytes.Buffer, values ...interface{}) {
+	for _, v := range values {
+		_ = binary.Write(buf, binary.BigEndian, v)
+	}
+}
+
+// square returns a closed ring around the square, clockwise unless reversed.
+func square(minX, minY, maxX, maxY float64, counterClockwise bool) [][2]float64 {
+	ring := [][2]float64{{minX, minY}, {minX, maxY}, {maxX, maxY}, {maxX, minY}, {minX, minY}}
+	if counterClockwise {
+		slices.Reverse(ring)
+	}
+	return ring
+}
+
+func queryCodes(t *testing.T, index *SpatialIndex, x, y float64) []int {
+	t.Helper()
+
+	results, err := QuerySpatialIndex(index, x, y)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var codes []int
+	for _, result := range results {
+		codes = append(codes, result["jordart"].(int))
+	}
+	slices.Sort(codes)
+	return codes
+}
+
+func TestQuerySpatialIndex(t *testing.T) {
+	basename := writeTestShapefile(t, []testPolygon{
+		// Two triangles splitting a square along its diagonal, their bounding boxes are equal
+		{jordart: 11, rings: [][][2]float64{{{0, 0}, {0, 10}, {10, 0}, {0, 0}}}},
+		{jordart: 90, rings: [][][2]float64{{{10, 0}, {0, 10}, {10, 10}, {10, 0}}}},
+		// A square with a hole, and an island inside the hole as a second polygon in the same record
+		{jordart: 40, rings: [][][2]float64{
+			square(100, 100, 110, 110, false),
+			square(103, 103, 107, 107, true),
+			square(104, 104, 106, 106, false),
+		}},
+		// A separate polygon in the hole, not overlapping the island
+		{jordart: 20, rings: [][][2]float64{square(103.5, 106.2, 106.5, 106.8, false)}},
+	})
+
+	index := ReadShapeFilesAndBuildIndex([]string{basename})
+
+	tests := []struct {
+		name string
+		x, y float64
+		want []int
+	}{
+		{name: "lower triangle", x: 2, y: 2, want: []int{11}},
+		{name: "upper triangle", x: 8, y: 8, want: []int{90}},
+		{name: "outside everything", x: 50, y: 50, want: nil},
+		{name: "polygon outside hole", x: 101, y: 101, want: []int{40}},
+		{name: "island inside hole", x: 105, y: 105, want: []int{40}},
+		{name: "hole without island", x: 103.5, y: 103.5, want: nil},
+		{name: "separate polygon in hole", x: 105, y: 106.5, want: []int{20}},
+		{name: "inside bbox but outside polygon", x: 111, y: 105, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := queryCodes(t, index, tt.x, tt.y)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("QuerySpatialIndex(%v, %v) = %v, want %v", tt.x, tt.y, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryReturnsBoundingBoxCandidates(t *testing.T) {
+	basename := writeTestShapefile(t, []testPolygon{
+		{jordart: 11, rings: [][][2]float64{{{0, 0}, {0, 10}, {10, 0}, {0, 0}}}},
+		{jordart: 90, rings: [][][2]float64{{{10, 0}, {0, 10}, {10, 10}, {10, 0}}}},
+	})
+
+	index := ReadShapeFilesAndBuildIndex([]string{basename})
+
+	if got := len(index.Query(8, 8, 8, 8)); got != 2 {
+		t.Errorf("Query returned %d candidates, want 2", got)
+	}
+	if got := len(index.QueryPoint(8, 8)); got != 1 {
+		t.Errorf("QueryPoint returned %d results, want 1", got)
+	}
+}
+
+func TestRingContainsPointConcave(t *testing.T) {
+	// A U-shaped polygon, the notch between the arms is outside
+	basename := writeTestShapefile(t, []testPolygon{
+		{jordart: 12, rings: [][][2]float64{{{0, 0}, {0, 10}, {3, 10}, {3, 3}, {7, 3}, {7, 10}, {10, 10}, {10, 0}, {0, 0}}}},
+	})
+
+	index := ReadShapeFilesAndBuildIndex([]string{basename})
+
+	if got := queryCodes(t, index, 5, 8); got != nil {
+		t.Errorf("point in notch = %v, want none", got)
+	}
+	if got := queryCodes(t, index, 1, 8); !slices.Equal(got, []int{12}) {
+		t.Errorf("point in arm = %v, want [12]", got)
+	}
+	if got := queryCodes(t, index, 5, 1); !slices.Equal(got, []int{12}) {
+		t.Errorf("point in base = %v, want [12]", got)
+	}
+}
@@ -0,0 +1,232 @@
+package projection
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Internal is the CRS coordinates are stored and processed in
+const Internal = "EPSG:25833"
+
+// _internalZone is the UTM zone of the internal CRS
+const _internalZone = 33
+
+// The extent of the mainland of Norway and its islands in degrees, roads are only served within it
+const (
+	WestBoundLongitude = 4.0
+	EastBoundLongitude = 31.5
+	SouthBoundLatitude = 57.8
+	NorthBoundLatitude = 71.5
+)
+
+// ErrOutsideNorway is returned for bounding boxes that do not overlap the extent of Norway
+var ErrOutsideNorway = errors.New("the bounding box is outside Norway")
+
+// CRS is a coordinate reference system that coordinates can be transformed to and from EPSG:25833.
+type CRS struct {
+	// Code is the name of the CRS, ex: EPSG:3857
+	Code string
+	// Geographic is true when the coordinates are longitude and latitude in degrees
+	Geographic bool
+	// LatLon is true when the official axis order is latitude first, ex: EPSG:4326 in WMS 1.3.0.
+	// Coordinates passed to the transforms are always x, or longitude, first.
+	LatLon bool
+
+	toLonLat   func(x, y float64) (float64, float64)
+	fromLonLat func(lon, lat float64) (float64, float64)
+}
+
+// _crsByCode are the supported CRSs, by their code
+var _crsByCode = map[string]CRS{
+	Internal: {Code: Internal},
+	"EPSG:3857": {
+		Code:       "EPSG:3857",
+		toLonLat:   WebMercatorToLonLat,
+		fromLonLat: LonLatToWebMercator,
+	},
+	"EPSG:4326": {
+		Code:       "EPSG:4326",
+		Geographic: true,
+		LatLon:     true,
+		toLonLat:   lonLatIdentity,
+		fromLonLat: lonLatIdentity,
+	},
+	"CRS:84": {
+		Code:       "CRS:84",
+		Geographic: true,
+		toLonLat:   lonLatIdentity,
+		fromLonLat: lonLatIdentity,
+	},
+	// UTM zones 32 to 35 cover Norway. WGS 84 and ETRS89 differ by less than a meter in Norway, so the
+	// WGS 84 zones are transformed as the ETRS89 zones.
+	"EPSG:25832": utmCRS("EPSG:25832", 32),
+	"EPSG:25835": utmCRS("EPSG:25835", 35),
+	"EPSG:32632": utmCRS("EPSG:32632", 32),
+	"EPSG:32633": utmCRS("EPSG:32633", 33),
+	"EPSG:32635": utmCRS("EPSG:32635", 35),
+}
+
+// SupportedCRS returns the codes of the supported CRSs, the internal CRS first.
+func SupportedCRS() []string {
+	return []string{Internal, "EPSG:3857", "EPSG:4326", "CRS:84", "EPSG:25832", "EPSG:25835", "EPSG:32632", "EPSG:32633", "EPSG:32635"}
+}
+
+// ParseCRS returns the CRS of a name, as a code or an OGC URN or URI.
+// ex: EPSG:3857, urn:ogc:def:crs:EPSG::3857, http://www.opengis.net/def/crs/EPSG/0/3857, CRS:84
+func ParseCRS(name string) (CRS, error) {
+	code := strings.ToUpper(strings.TrimSpace(name))
+	switch {
+	case strings.HasSuffix(code, "CRS84") || code == "CRS:84":
+		code = "CRS:84"
+	case strings.HasPrefix(code, "URN:OGC:DEF:CRS:EPSG:"):
+		code = "EPSG:" + code[strings.LastIndex(code, ":")+1:]
+	case strings.Contains(code, "/DEF/CRS/EPSG/"):
+		code = "EPSG:" + code[strings.LastIndex(code, "/")+1:]
+	}
+
+	if _, err := strconv.Atoi(strings.TrimPrefix(code, "EPSG:")); code != "CRS:84" && err != nil {
+		return CRS{}, fmt.Errorf("invalid CRS %s", name)
+	}
+
+	crs, ok := _crsByCode[code]
+	if !ok {
+		return CRS{}, fmt.Errorf("unsupported CRS %s, supported are %s", name, strings.Join(SupportedCRS(), ", "))
+	}
+	return crs, nil
+}
+
+// IsInternal checks if the CRS is the internal CRS, which needs no transform.
+func (crs CRS) IsInternal() bool {
+	return crs.Code == Internal
+}
+
+// ToInternal transforms a coordinate in the CRS to EPSG:25833.
+func (crs CRS) ToInternal(x, y float64) (float64, float64) {
+	if crs.IsInternal() {
+		return x, y
+	}
+	lon, lat := crs.toLonLat(x, y)
+	return LonLatToUTM(lon, lat, _internalZone)
+}
+
+// FromInternal transforms a coordinate in EPSG:25833 to the CRS.
+func (crs CRS) FromInternal(x, y float64) (float64, float64) {
+	if crs.IsInternal() {
+		return x, y
+	}
+	lon, lat := UTMToLonLat(x, y, _internalZone)
+	return crs.fromLonLat(lon, lat)
+}
+
+// URN returns the OGC URN of the CRS, as used in the crs member of GeoJSON.
+// ex: urn:ogc:def:crs:EPSG::3857
+func (crs CRS) URN() string {
+	if crs.Code == "CRS:84" {
+		return "urn:ogc:def:crs:OGC:1.3:CRS84"
+	}
+	return "urn:ogc:def:crs:EPSG::" + strings.TrimPrefix(crs.Code, "EPSG:")
+}
+
+// URI returns the OGC URI of the CRS, as used by OGC API Features.
+// ex: http://www.opengis.net/def/crs/EPSG/0/3857
+func (crs CRS) URI() string {
+	if crs.Code == "CRS:84" {
+		return "http://www.opengis.net/def/crs/OGC/1.3/CRS84"
+	}
+	return "http://www.opengis.net/def/crs/EPSG/0/" + strings.TrimPrefix(crs.Code, "EPSG:")
+}
+
+// BoundsToInternal returns the EPSG:25833 bounding box that covers the bounding box in the CRS.
+func (crs CRS) BoundsToInternal(minX, minY, maxX, maxY float64) (float64, float64, float64, float64) {
+	return transformBounds(crs.ToInternal, minX, minY, maxX, maxY)
+}
+
+// CheckedBoundsToInternal returns the EPSG:25833 bounding box that covers the bounding box in the CRS, like
+// BoundsToInternal. Returns ErrOutsideNorway if the bounding box does not overlap Norway, and an error if it is
+// not finite or can not be transformed, the transform diverges far from the central meridian of EPSG:25833.
+func (crs CRS) CheckedBoundsToInternal(minX, minY, maxX, maxY float64) (float64, float64, float64, float64, error) {
+	if !isFinite(minX, minY, maxX, maxY) {
+		return 0, 0, 0, 0, fmt.Errorf("the bounding box is not finite")
+	}
+
+	west, south, east, north := transformBounds(crs.lonLat, minX, minY, maxX, maxY)
+	if !isFinite(west, south, east, north) {
+		return 0, 0, 0, 0, fmt.Errorf("the bounding box can not be transformed to %s", Internal)
+	}
+	if east < WestBoundLongitude || west > EastBoundLongitude || north < SouthBoundLatitude || south > NorthBoundLatitude {
+		return 0, 0, 0, 0, ErrOutsideNorway
+	}
+
+	minX, minY, maxX, maxY = crs.BoundsToInternal(minX, minY, maxX, maxY)
+	if !isFinite(minX, minY, maxX, maxY) {
+		return 0, 0, 0, 0, fmt.Errorf("the bounding box can not be transformed to %s", Internal)
+	}
+	return minX, minY, maxX, maxY, nil
+}
+
+// BoundsFromInternal returns the bounding box in the CRS that covers the EPSG:25833 bounding box.
+func (crs CRS) BoundsFromInternal(minX, minY, maxX, maxY float64) (float64, float64, float64, float64) {
+	return transformBounds(crs.FromInternal, minX, minY, maxX, maxY)
+}
+
+// lonLat transforms a coordinate in the CRS to longitude and latitude.
+func (crs CRS) lonLat(x, y float64) (float64, float64) {
+	if crs.IsInternal() {
+		return UTMToLonLat(x, y, _internalZone)
+	}
+	return crs.toLonLat(x, y)
+}
+
+// isFinite checks that none of the values are NaN or infinite.
+func isFinite(values ...float64) bool {
+	for _, value := range values {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// transformBounds returns the bounding box that covers the transformed bounding box.
+// The edges are sampled, since straight lines in one projection are curved in the other.
+func transformBounds(transform func(x, y float64) (float64, float64), minX, minY, maxX, maxY float64) (float64, float64, float64, float64) {
+	const samples = 8
+
+	outMinX, outMinY := math.Inf(1), math.Inf(1)
+	outMaxX, outMaxY := math.Inf(-1), math.Inf(-1)
+	for i := 0; i <= samples; i++ {
+		t := float64(i) / samples
+		for _, point := range [][2]float64{
+			{minX + t*(maxX-minX), minY},
+			{minX + t*(maxX-minX), maxY},
+			{minX, minY + t*(maxY-minY)},
+			{maxX, minY + t*(maxY-minY)},
+		} {
+			x, y := transform(point[0], point[1])
+			outMinX, outMaxX = math.Min(outMinX, x), math.Max(outMaxX, x)
+			outMinY, outMaxY = math.Min(outMinY, y), math.Max(outMaxY, y)
+		}
+	}
+
+	return outMinX, outMinY, outMaxX, outMaxY
+}
+
+// utmCRS returns the CRS of a UTM zone, with coordinates in meters.
+func utmCRS(code string, zone int) CRS {
+	return CRS{
+		Code: code,
+		toLonLat: func(x, y float64) (float64, float64) {
+			return UTMToLonLat(x, y, zone)
+		},
+		fromLonLat: func(lon, lat float64) (float64, float64) {
+			return LonLatToUTM(lon, lat, zone)
+		},
+	}
+}
+
+func lonLatIdentity(lon, lat float64) (float64, float64) {
+	return lon, lat
+}
@@ -0,0 +1,123 @@
+// Package projection transforms coordinates between EPSG:25833, the CRS used internally, and the CRS of clients.
+// The transforms are pure Go, ETRS89 and WGS84 are treated as the same datum, which is within a meter in Norway.
+package projection
+
+import "math"
+
+// GRS80 ellipsoid, used by ETRS89 and, within a millimetre, WGS84
+const (
+	_semiMajorAxis = 6378137.0
+	_flattening    = 1 / 298.257222101
+)
+
+// UTM parameters
+const (
+	_scaleFactor  = 0.9996
+	_falseEasting = 500000.0
+)
+
+// _webMercatorRadius is the sphere radius of Web Mercator, EPSG:3857
+const _webMercatorRadius = 6378137.0
+
+// Coefficients of the Krüger series for the transverse Mercator projection, to the fourth order of n.
+// They are accurate to well under a millimetre within a UTM zone.
+var (
+	_n                    = _flattening / (2 - _flattening)
+	_rectifyingRadius     = _semiMajorAxis / (1 + _n) * (1 + _n*_n/4 + math.Pow(_n, 4)/64)
+	_alpha, _beta, _delta = krugerCoefficients(_n)
+)
+
+func krugerCoefficients(n float64) ([4]float64, [4]float64, [4]float64) {
+	n2, n3, n4 := n*n, n*n*n, n*n*n*n
+
+	alpha := [4]float64{
+		n/2 - 2*n2/3 + 5*n3/16 + 41*n4/180,
+		13*n2/48 - 3*n3/5 + 557*n4/1440,
+		61*n3/240 - 103*n4/140,
+		49561 * n4 / 161280,
+	}
+	beta := [4]float64{
+		n/2 - 2*n2/3 + 37*n3/96 - n4/360,
+		n2/48 + n3/15 - 437*n4/1440,
+		17*n3/480 - 37*n4/840,
+		4397 * n4 / 161280,
+	}
+	delta := [4]float64{
+		2*n - 2*n2/3 - 2*n3 + 116*n4/45,
+		7*n2/3 - 8*n3/5 - 227*n4/45,
+		56*n3/15 - 136*n4/35,
+		4279 * n4 / 630,
+	}
+
+	return alpha, beta, delta
+}
+
+// LonLatToUTM projects a longitude and latitude in degrees to easting and northing in a northern UTM zone.
+func LonLatToUTM(lon, lat float64, zone int) (float64, float64) {
+	phi := lat * math.Pi / 180
+	lambda := (lon - centralMeridian(zone)) * math.Pi / 180
+
+	e := 2 * math.Sqrt(_n) / (1 + _n)
+	t := math.Sinh(math.Atanh(math.Sin(phi)) - e*math.Atanh(e*math.Sin(phi)))
+	xi := math.Atan2(t, math.Cos(lambda))
+	eta := math.Atanh(math.Sin(lambda) / math.Sqrt(1+t*t))
+
+	easting, northing := eta, xi
+	for j := 1; j <= 4; j++ {
+		a := _alpha[j-1]
+		easting += a * math.Cos(2*float64(j)*xi) * math.Sinh(2*float64(j)*eta)
+		northing += a * math.Sin(2*float64(j)*xi) * math.Cosh(2*float64(j)*eta)
+	}
+
+	return _falseEasting + _scaleFactor*_rectifyingRadius*easting, _scaleFactor * _rectifyingRadius * northing
+}
+
+// UTMToLonLat unprojects easting and northing in a northern UTM zone to longitude and latitude in degrees.
+func UTMToLonLat(easting, northing float64, zone int) (float64, float64) {
+	xi := northing / (_scaleFactor * _rectifyingRadius)
+	eta := (easting - _falseEasting) / (_scaleFactor * _rectifyingRadius)
+
+	xiPrime, etaPrime := xi, eta
+	for j := 1; j <= 4; j++ {
+		b := _beta[j-1]
+		xiPrime -= b * math.Sin(2*float64(j)*xi) * math.Cosh(2*float64(j)*eta)
+		etaPrime -= b * math.Cos(2*float64(j)*xi) * math.Sinh(2*float64(j)*eta)
+	}
+
+	chi := math.Asin(math.Sin(xiPrime) / math.Cosh(etaPrime))
+	phi := chi
+	for j := 1; j <= 4; j++ {
+		phi += _delta[j-1] * math.Sin(2*float64(j)*chi)
+	}
+	lambda := math.Atan2(math.Sinh(etaPrime), math.Cos(xiPrime))
+
+	return centralMeridian(zone) + lambda*180/math.Pi, phi * 180 / math.Pi
+}
+
+// LonLatToWebMercator projects a longitude and latitude in degrees to Web Mercator meters.
+func LonLatToWebMercator(lon, lat float64) (float64, float64) {
+	x := _webMercatorRadius * lon * math.Pi / 180
+	y := _webMercatorRadius * math.Log(math.Tan(math.Pi/4+lat*math.Pi/360))
+	return x, y
+}
+
+// WebMercatorToLonLat unprojects Web Mercator meters to longitude and latitude in degrees.
+func WebMercatorToLonLat(x, y float64) (float64, float64) {
+	lon := x / _webMercatorRadius * 180 / math.Pi
+	lat := (2*math.Atan(math.Exp(y/_webMercatorRadius)) - math.Pi/2) * 180 / math.Pi
+	return lon, lat
+}
+
+// TileBounds returns the bounds of an XYZ tile in Web Mercator meters, minx, miny, maxx, maxy.
+func TileBounds(z, x, y int) (float64, float64, float64, float64) {
+	size := 2 * math.Pi * _webMercatorRadius / math.Exp2(float64(z))
+	origin := math.Pi * _webMercatorRadius
+
+	minX := -origin + float64(x)*size
+	maxY := origin - float64(y)*size
+	return minX, maxY - size, minX + size, maxY
+}
+
+func centralMeridian(zone int) float64 {
+	return float64(zone)*6 - 183
+}
@@ -0,0 +1,246 @@
+package projection
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// _webMercatorMax is half the circumference of the Web Mercator sphere, the edge of the tile grid
+const _webMercatorMax = 20037508.342789244
+
+// Reference points, projected with the Krüger series to the third order of n, accurate to a millimetre
+var _utmReferences = []struct {
+	name              string
+	lon, lat          float64
+	zone              int
+	easting, northing float64
+}{
+	{name: "central meridian 33 at 60N", lon: 15, lat: 60, zone: 33, easting: 500000, northing: 6651411.190},
+	{name: "central meridian 32 at 60N", lon: 9, lat: 60, zone: 32, easting: 500000, northing: 6651411.190},
+	{name: "central meridian 35 at 60N", lon: 27, lat: 60, zone: 35, easting: 500000, northing: 6651411.190},
+	{name: "equator", lon: 15, lat: 0, zone: 33, easting: 500000, northing: 0},
+	{name: "Oslo in 32", lon: 10.75, lat: 59.91, zone: 32, easting: 597868.381, northing: 6642681.510},
+	{name: "Oslo in 33", lon: 10.75, lat: 59.91, zone: 33, easting: 262409.732, northing: 6649017.749},
+	{name: "Bergen in 32", lon: 5.32, lat: 60.39, zone: 32, easting: 297230.220, northing: 6700510.175},
+	{name: "Bergen in 33", lon: 5.32, lat: 60.39, zone: 33, easting: -32253.597, northing: 6734074.911},
+	{name: "Tromsø in 33", lon: 18.95, lat: 69.65, zone: 33, easting: 653210.089, northing: 7731796.830},
+	{name: "Kirkenes in 35", lon: 30.05, lat: 69.73, zone: 35, easting: 617884.869, northing: 7738708.104},
+}
+
+func TestLonLatToUTM(t *testing.T) {
+	for _, tt := range _utmReferences {
+		t.Run(tt.name, func(t *testing.T) {
+			easting, northing := LonLatToUTM(tt.lon, tt.lat, tt.zone)
+			if math.Abs(easting-tt.easting) > 0.01 || math.Abs(northing-tt.northing) > 0.01 {
+				t.Errorf("LonLatToUTM(%v, %v, %d) = %.3f, %.3f, want %.3f, %.3f",
+					tt.lon, tt.lat, tt.zone, easting, northing, tt.easting, tt.northing)
+			}
+		})
+	}
+}
+
+func TestUTMToLonLat(t *testing.T) {
+	for _, tt := range _utmReferences {
+		t.Run(tt.name, func(t *testing.T) {
+			lon, lat := UTMToLonLat(tt.easting, tt.northing, tt.zone)
+			// 1e-7 degrees is about a centimetre
+			if math.Abs(lon-tt.lon) > 1e-7 || math.Abs(lat-tt.lat) > 1e-7 {
+				t.Errorf("UTMToLonLat(%.3f, %.3f, %d) = %v, %v, want %v, %v",
+					tt.easting, tt.northing, tt.zone, lon, lat, tt.lon, tt.lat)
+			}
+		})
+	}
+}
+
+func TestWebMercator(t *testing.T) {
+	tests := []struct {
+		name     string
+		lon, lat float64
+		x, y     float64
+	}{
+		{name: "origin", lon: 0, lat: 0, x: 0, y: 0},
+		{name: "antimeridian", lon: 180, lat: 0, x: _webMercatorMax, y: 0},
+		{name: "edge of the tile grid", lon: -180, lat: -85.0511287798066, x: -_webMercatorMax, y: -_webMercatorMax},
+		{name: "10E 60N", lon: 10, lat: 60, x: 1113194.908, y: 8399737.890},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x, y := LonLatToWebMercator(tt.lon, tt.lat)
+			if math.Abs(x-tt.x) > 0.01 || math.Abs(y-tt.y) > 0.01 {
+				t.Errorf("LonLatToWebMercator(%v, %v) = %.3f, %.3f, want %.3f, %.3f", tt.lon, tt.lat, x, y, tt.x, tt.y)
+			}
+
+			lon, lat := WebMercatorToLonLat(tt.x, tt.y)
+			if math.Abs(lon-tt.lon) > 1e-7 || math.Abs(lat-tt.lat) > 1e-7 {
+				t.Errorf("WebMercatorToLonLat(%.3f, %.3f) = %v, %v, want %v, %v", tt.x, tt.y, lon, lat, tt.lon, tt.lat)
+			}
+		})
+	}
+}
+
+func TestTileBounds(t *testing.T) {
+	tests := []struct {
+		z, x, y int
+		want    [4]float64
+	}{
+		{z: 0, x: 0, y: 0, want: [4]float64{-_webMercatorMax, -_webMercatorMax, _webMercatorMax, _webMercatorMax}},
+		{z: 1, x: 0, y: 0, want: [4]float64{-_webMercatorMax, 0, 0, _webMercatorMax}},
+		{z: 1, x: 1, y: 1, want: [4]float64{0, -_webMercatorMax, _webMercatorMax, 0}},
+	}
+
+	for _, tt := range tests {
+		minX, minY, maxX, maxY := TileBounds(tt.z, tt.x, tt.y)
+		got := [4]float64{minX, minY, maxX, maxY}
+		for i := range got {
+			if math.Abs(got[i]-tt.want[i]) > 1e-6 {
+				t.Errorf("TileBounds(%d, %d, %d) = %v, want %v", tt.z, tt.x, tt.y, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestCRSRoundTrip(t *testing.T) {
+	// Points in EPSG:25833 across Norway, from Lindesnes to Kirkenes
+	points := [][2]float64{{-32253.597, 6734074.911}, {262409.732, 6649017.749}, {653210.089, 7731796.830}, {1073000, 7800000}}
+
+	for _, code := range SupportedCRS() {
+		crs, err := ParseCRS(code)
+		if err != nil {
+			t.Fatalf("ParseCRS(%q) returned %v", code, err)
+		}
+
+		for _, point := range points {
+			x, y := crs.FromInternal(point[0], point[1])
+			backX, backY := crs.ToInternal(x, y)
+			if math.Abs(backX-point[0]) > 0.001 || math.Abs(backY-point[1]) > 0.001 {
+				t.Errorf("%s: %v -> %v, %v -> %v, %v", code, point, x, y, backX, backY)
+			}
+		}
+	}
+}
+
+func TestCRSFromInternal(t *testing.T) {
+	tests := []struct {
+		code string
+		x, y float64
+	}{
+		{code: "EPSG:25832", x: 597868.381, y: 6642681.510},
+		{code: "EPSG:32632", x: 597868.381, y: 6642681.510},
+		{code: "EPSG:4326", x: 10.75, y: 59.91},
+		{code: "CRS:84", x: 10.75, y: 59.91},
+		{code: "EPSG:3857", x: 1196684.526, y: 8379727.582},
+	}
+
+	// Oslo in EPSG:25833
+	const internalX, internalY = 262409.732, 6649017.749
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			crs, err := ParseCRS(tt.code)
+			if err != nil {
+				t.Fatalf("ParseCRS(%q) returned %v", tt.code, err)
+			}
+
+			x, y := crs.FromInternal(internalX, internalY)
+			tolerance := 0.01
+			if crs.Geographic {
+				tolerance = 1e-7
+			}
+			if math.Abs(x-tt.x) > tolerance || math.Abs(y-tt.y) > tolerance {
+				t.Errorf("FromInternal(%v, %v) = %v, %v, want %v, %v", internalX, internalY, x, y, tt.x, tt.y)
+			}
+		})
+	}
+}
+
+func TestParseCRS(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "EPSG:25833", want: "EPSG:25833"},
+		{name: "epsg:25832", want: "EPSG:25832"},
+		{name: "urn:ogc:def:crs:EPSG::25835", want: "EPSG:25835"},
+		{name: "urn:ogc:def:crs:EPSG:6.18:3:3857", want: "EPSG:3857"},
+		{name: "http://www.opengis.net/def/crs/EPSG/0/4326", want: "EPSG:4326"},
+		{name: "https://www.opengis.net/def/crs/EPSG/0/32633", want: "EPSG:32633"},
+		{name: "CRS:84", want: "CRS:84"},
+		{name: "urn:ogc:def:crs:OGC:1.3:CRS84", want: "CRS:84"},
+		{name: "http://www.opengis.net/def/crs/OGC/1.3/CRS84", want: "CRS:84"},
+		{name: "EPSG:9999", wantErr: true},
+		{name: "EPSG:abc", wantErr: true},
+		{name: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			crs, err := ParseCRS(tt.name)
+			if (err != nil) != tt.wantErr || crs.Code != tt.want {
+				t.Errorf("ParseCRS(%q) = %q, %v, want %q, error %v", tt.name, crs.Code, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCRSNames(t *testing.T) {
+	tests := []struct {
+		code, urn, uri string
+	}{
+		{code: "EPSG:25833", urn: "urn:ogc:def:crs:EPSG::25833", uri: "http://www.opengis.net/def/crs/EPSG/0/25833"},
+		{code: "CRS:84", urn: "urn:ogc:def:crs:OGC:1.3:CRS84", uri: "http://www.opengis.net/def/crs/OGC/1.3/CRS84"},
+	}
+
+	for _, tt := range tests {
+		crs, _ := ParseCRS(tt.code)
+		if crs.URN() != tt.urn || crs.URI() != tt.uri {
+			t.Errorf("%s: URN() = %s, URI() = %s, want %s, %s", tt.code, crs.URN(), crs.URI(), tt.urn, tt.uri)
+		}
+
+		for _, name := range []string{tt.urn, tt.uri} {
+			parsed, err := ParseCRS(name)
+			if err != nil || parsed.Code != tt.code {
+				t.Errorf("ParseCRS(%q) = %q, %v, want %q", name, parsed.Code, err, tt.code)
+			}
+		}
+	}
+}
+
+func TestCheckedBoundsToInternal(t *testing.T) {
+	tests := []struct {
+		name                   string
+		code                   string
+		minX, minY, maxX, maxY float64
+		wantErr                error
+		wantOK                 bool
+	}{
+		{name: "Oslo", code: "CRS:84", minX: 10.7, minY: 59.9, maxX: 10.8, maxY: 60.0, wantOK: true},
+		{name: "Oslo internal", code: "EPSG:25833", minX: 261000, minY: 6647000, maxX: 263000, maxY: 6650000, wantOK: true},
+		{name: "edge of Norway", code: "CRS:84", minX: 0, minY: 60, maxX: 4.5, maxY: 61, wantOK: true},
+		{name: "outside Norway", code: "CRS:84", minX: -80, minY: 0, maxX: -70, maxY: 1, wantErr: ErrOutsideNorway},
+		{name: "outside Norway in Web Mercator", code: "EPSG:3857", minX: 261000, minY: 6647000, maxX: 263000, maxY: 6650000, wantErr: ErrOutsideNorway},
+		{name: "outside UTM", code: "EPSG:25833", minX: 1e8, minY: 1e8, maxX: 1e9, maxY: 1e9},
+		{name: "too far from the central meridian", code: "CRS:84", minX: -75, minY: 0, maxX: 10, maxY: 60},
+		{name: "not finite", code: "EPSG:25833", minX: math.Inf(-1), minY: 6647000, maxX: 263000, maxY: 6650000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			crs, err := ParseCRS(tt.code)
+			if err != nil {
+				t.Fatalf("ParseCRS(%q) returned %v", tt.code, err)
+			}
+
+			minX, minY, maxX, maxY, err := crs.CheckedBoundsToInternal(tt.minX, tt.minY, tt.maxX, tt.maxY)
+			if (err == nil) != tt.wantOK || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Fatalf("CheckedBoundsToInternal(%v, %v, %v, %v) returned %v, want %v", tt.minX, tt.minY, tt.maxX, tt.maxY, err, tt.wantErr)
+			}
+			if err == nil && !isFinite(minX, minY, maxX, maxY) {
+				t.Errorf("CheckedBoundsToInternal(%v, %v, %v, %v) = %v, %v, %v, %v, want finite bounds", tt.minX, tt.minY, tt.maxX, tt.maxY, minX, minY, maxX, maxY)
+			}
+		})
+	}
+}
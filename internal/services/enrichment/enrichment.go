@@ -0,0 +1,47 @@
+// Package enrichment adds superficial deposits, SeNorge grid data and trafficability to forestry roads.
+package enrichment
+
+import (
+	"context"
+	"skogkursbachelor/server/internal/models"
+	"skogkursbachelor/server/internal/services/senorge"
+	"skogkursbachelor/server/internal/services/superficialdeposits"
+	"skogkursbachelor/server/internal/services/trafficability"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Enrich sets every enriched property on the roads in the feature map, clustered by
+// WFSResponse.ClusterWFSResponseToShardedMap. Failed enrichments are left as null, and described in the returned
+// warnings. Without a start date, SeNorge is skipped, ex: when enriching offline.
+// When the context is cancelled, the remaining steps are skipped, check the context before using the result.
+func Enrich(ctx context.Context, featureMap *map[string][]models.ForestRoad, startDate, endDate string) []models.Warning {
+	var warnings []models.Warning
+
+	// Superficial deposits
+	err := superficialdeposits.UpdateSuperficialDepositCodes(ctx, featureMap)
+	if ctx.Err() != nil {
+		return warnings
+	}
+	if err != nil {
+		log.Error().Msg("Error updating superficial deposit data: " + err.Error())
+		warnings = append(warnings, models.Warning{Source: "superficialdeposits", Message: err.Error()})
+	}
+
+	// SeNorge grid data, frost depth, water saturation and configured themes
+	if startDate != "" {
+		warnings = append(warnings, senorge.UpdateGridData(ctx, featureMap, startDate, endDate)...)
+		if ctx.Err() != nil {
+			return warnings
+		}
+	}
+
+	// Trafficability, combines the data above into a class per road
+	err = trafficability.UpdateTrafficability(featureMap)
+	if err != nil {
+		log.Error().Msg("Error updating trafficability: " + err.Error())
+		warnings = append(warnings, models.Warning{Source: "trafficability", Message: err.Error()})
+	}
+
+	return warnings
+}
@@ -0,0 +1,293 @@
+// Package forestryroads gets the enriched forestry roads in a bounding box, from the local road store or the
+// GeoNorge WFS. It is the pipeline of the endpoints that query roads by bbox themselves, ex: vector tiles,
+// instead of mirroring a WFS query from the client.
+package forestryroads
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"skogkursbachelor/server/internal/constants"
+	"skogkursbachelor/server/internal/http/upstream"
+	"skogkursbachelor/server/internal/models"
+	"skogkursbachelor/server/internal/projection"
+	"skogkursbachelor/server/internal/services/enrichment"
+	"skogkursbachelor/server/internal/services/roadstore"
+	"skogkursbachelor/server/internal/services/senorge"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// _wfsOutputFormat is the GeoJSON output format of the WFS
+const _wfsOutputFormat = "application/json"
+
+// _typeName is the WFS feature type of the forestry roads, see TypeName
+var (
+	_typeName   string
+	_typeNameMu sync.Mutex
+)
+
+// GetRoads returns the forestry roads in the bbox, in EPSG:25833, without enrichment.
+// Roads are read from the local store if it is open, otherwise from the GeoNorge WFS.
+// Errors from the WFS wrap upstream errors, ex: upstream.ErrCircuitOpen.
+func GetRoads(ctx context.Context, minX, minY, maxX, maxY float64) (models.WFSResponse, error) {
+	if roadstore.Enabled() {
+		roads, err := roadstore.Query(minX, minY, maxX, maxY)
+		if err != nil {
+			return models.WFSResponse{}, fmt.Errorf("failed to query forestry road store: %w", err)
+		}
+
+		response := models.WFSResponse{
+			Type:          "FeatureCollection",
+			NumberMatched: len(roads),
+			Name:          "forestryroads",
+			Date:          time.Now().Format(time.RFC3339),
+			Features:      roads,
+		}
+		response.Crs.Type = "name"
+		response.Crs.Properties.Name = "urn:ogc:def:crs:EPSG::25833"
+		return response, nil
+	}
+
+	return fetchRoads(ctx, minX, minY, maxX, maxY)
+}
+
+// GetEnrichedRoads returns the forestry roads in the bbox, enriched for the dates like the forestry road endpoint.
+// Failed enrichments are left as null, and described in the warnings of the response.
+// When the context is cancelled, the context error is returned.
+func GetEnrichedRoads(ctx context.Context, minX, minY, maxX, maxY float64, startDate, endDate string) (models.WFSResponse, error) {
+	response, err := GetRoads(ctx, minX, minY, maxX, maxY)
+	if err != nil {
+		return models.WFSResponse{}, err
+	}
+	if len(response.Features) == 0 {
+		return response, nil
+	}
+
+	response.Warnings, err = Enrich(ctx, response.Features, startDate, endDate)
+	if err != nil {
+		return models.WFSResponse{}, err
+	}
+	if startDate != "" {
+		response.Enheter = senorge.Units()
+	}
+	return response, nil
+}
+
+// Enrich enriches the roads in place, keeping their order. Failed enrichments are left as null, and described
+// in the returned warnings. When the context is cancelled, the context error is returned.
+func Enrich(ctx context.Context, roads []models.ForestRoad, startDate, endDate string) ([]models.Warning, error) {
+	// Cluster the roads like ClusterWFSResponseToShardedMap, remembering where each road was
+	featureMap := make(map[string][]models.ForestRoad)
+	positions := make(map[string][]int)
+	for i, road := range roads {
+		key := models.ClusterKey(road)
+		featureMap[key] = append(featureMap[key], road)
+		positions[key] = append(positions[key], i)
+	}
+
+	warnings := enrichment.Enrich(ctx, &featureMap, startDate, endDate)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	for key, clusterRoads := range featureMap {
+		for i, road := range clusterRoads {
+			roads[positions[key][i]] = road
+		}
+	}
+	return warnings, nil
+}
+
+// Reproject transforms the roads of the response from EPSG:25833 to the CRS, and sets the CRS of the response.
+func Reproject(response *models.WFSResponse, crs projection.CRS) {
+	response.Crs.Type = "name"
+	response.Crs.Properties.Name = crs.URN()
+	ReprojectRoads(response.Features, crs)
+}
+
+// ReprojectRoads transforms the roads from EPSG:25833 to the CRS, in place. Coordinates are in the axis order
+// of the CRS, latitude first in EPSG:4326, as the CRS is named by its EPSG URN. Use CRS:84 for longitude first.
+func ReprojectRoads(roads []models.ForestRoad, crs projection.CRS) {
+	if crs.IsInternal() {
+		return
+	}
+
+	for i := range roads {
+		coordinates := make([][]float64, len(roads[i].Geometry.Coordinates))
+		for j, coordinate := range roads[i].Geometry.Coordinates {
+			x, y := crs.FromInternal(coordinate[0], coordinate[1])
+			if crs.LatLon {
+				x, y = y, x
+			}
+			coordinates[j] = []float64{x, y}
+		}
+		roads[i].Geometry.Coordinates = coordinates
+	}
+}
+
+// fetchRoads gets the roads in the bbox with a WFS GetFeature request.
+func fetchRoads(ctx context.Context, minX, minY, maxX, maxY float64) (models.WFSResponse, error) {
+	typeName, err := TypeName(ctx)
+	if err != nil {
+		return models.WFSResponse{}, err
+	}
+
+	query := url.Values{}
+	query.Set("service", "WFS")
+	query.Set("version", "2.0.0")
+	query.Set("request", "GetFeature")
+	query.Set("typeNames", typeName)
+	query.Set("srsName", "EPSG:25833")
+	query.Set("outputFormat", _wfsOutputFormat)
+	query.Set("bbox", formatBBox(minX, minY, maxX, maxY)+",EPSG:25833")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, constants.ForestryRoadsWFS+"?"+query.Encode(), nil)
+	if err != nil {
+		return models.WFSResponse{}, err
+	}
+
+	resp, err := upstream.Do(req)
+	if err != nil {
+		return models.WFSResponse{}, fmt.Errorf("failed to fetch forestry roads from the WFS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return models.WFSResponse{}, fmt.Errorf("the WFS responded with status %s", resp.Status)
+	}
+
+	var response models.WFSResponse
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return models.WFSResponse{}, fmt.Errorf("failed to decode WFS response: %v", err)
+	}
+
+	return response, nil
+}
+
+// TypeName returns the feature type of the forestry roads in the WFS. It is read from $FORESTRY_ROADS_WFS_TYPENAME,
+// or else the first feature type in the capabilities of the WFS, which is fetched once.
+// Errors wrap upstream errors, ex: upstream.ErrCircuitOpen.
+func TypeName(ctx context.Context) (string, error) {
+	if typeName := os.Getenv("FORESTRY_ROADS_WFS_TYPENAME"); typeName != "" {
+		return typeName, nil
+	}
+
+	_typeNameMu.Lock()
+	defer _typeNameMu.Unlock()
+	if _typeName != "" {
+		return _typeName, nil
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		constants.ForestryRoadsWFS+"?service=WFS&version=2.0.0&request=GetCapabilities",
+		nil,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := upstream.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch WFS capabilities: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var capabilities struct {
+		FeatureTypes []struct {
+			Name string `xml:"Name"`
+		} `xml:"FeatureTypeList>FeatureType"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&capabilities)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode WFS capabilities: %v", err)
+	}
+	if len(capabilities.FeatureTypes) == 0 || capabilities.FeatureTypes[0].Name == "" {
+		return "", fmt.Errorf("the WFS capabilities have no feature types")
+	}
+
+	_typeName = capabilities.FeatureTypes[0].Name
+	log.Info().Msgf("Using WFS feature type %s for forestry roads", _typeName)
+	return _typeName, nil
+}
+
+// formatBBox formats a bbox as "minx,miny,maxx,maxy".
+func formatBBox(minX, minY, maxX, maxY float64) string {
+	return strconv.FormatFloat(minX, 'f', -1, 64) + "," +
+		strconv.FormatFloat(minY, 'f', -1, 64) + "," +
+		strconv.FormatFloat(maxX, 'f', -1, 64) + "," +
+		strconv.FormatFloat(maxY, 'f', -1, 64)
+}
+
+// SetFeatureIDs sets a stable id on every road, the 1000x1000 meter square its middle coordinate is in, its
+// vegnummer, strekningnummer and delstrekningnummer, and a checksum of its geometry, ex: 262500_6649500_1234_1_2_9f3c0a1e.
+// The id only depends on the road, so a road has the same id whatever query it is found by.
+func SetFeatureIDs(roads []models.ForestRoad) {
+	for i := range roads {
+		properties := roads[i].Properties
+		roads[i].ID = strings.Replace(models.ClusterKey(roads[i]), ",", "_", 1) + "_" +
+			properties.Vegnummer + "_" + properties.Strekningnummer + "_" + properties.Delstrekningnummer + "_" +
+			geometryChecksum(roads[i])
+	}
+}
+
+// geometryChecksum returns the CRC-32 of the coordinates of the road, as 8 hexadecimal digits.
+// Roads in the same square with the same numbers are told apart by it.
+func geometryChecksum(road models.ForestRoad) string {
+	checksum := crc32.NewIEEE()
+	var buffer [8]byte
+	for _, coordinate := range road.Geometry.Coordinates {
+		for _, value := range coordinate {
+			binary.LittleEndian.PutUint64(buffer[:], math.Float64bits(value))
+			_, _ = checksum.Write(buffer[:])
+		}
+	}
+	return fmt.Sprintf("%08x", checksum.Sum32())
+}
+
+// GetEnrichedRoad returns the road with the id set by SetFeatureIDs, enriched for the dates.
+// Returns false if there is no road with the id.
+func GetEnrichedRoad(ctx context.Context, id, startDate, endDate string) (models.ForestRoad, []models.Warning, bool, error) {
+	parts := strings.SplitN(id, "_", 3)
+	if len(parts) != 3 {
+		return models.ForestRoad{}, nil, false, nil
+	}
+	x, errX := strconv.Atoi(parts[0])
+	y, errY := strconv.Atoi(parts[1])
+	if errX != nil || errY != nil {
+		return models.ForestRoad{}, nil, false, nil
+	}
+
+	// The road has its middle coordinate in the square, so it is among the roads in the square
+	response, err := GetRoads(ctx, float64(x-500), float64(y-500), float64(x+500), float64(y+500))
+	if err != nil {
+		return models.ForestRoad{}, nil, false, err
+	}
+	SetFeatureIDs(response.Features)
+
+	for _, road := range response.Features {
+		if road.ID != id {
+			continue
+		}
+
+		roads := []models.ForestRoad{road}
+		warnings, err := Enrich(ctx, roads, startDate, endDate)
+		return roads[0], warnings, true, err
+	}
+
+	return models.ForestRoad{}, nil, false, nil
+}
@@ -0,0 +1,76 @@
+package forestryroads
+
+import (
+	"math"
+	"skogkursbachelor/server/internal/models"
+	"skogkursbachelor/server/internal/projection"
+	"strings"
+	"testing"
+)
+
+func TestReproject(t *testing.T) {
+	tests := []struct {
+		code string
+		want []float64
+	}{
+		{code: "EPSG:25833", want: []float64{262409.732, 6649017.749}},
+		{code: "EPSG:4326", want: []float64{59.91, 10.75}},
+		{code: "CRS:84", want: []float64{10.75, 59.91}},
+		{code: "EPSG:3857", want: []float64{1196684.526, 8379727.582}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			crs, err := projection.ParseCRS(tt.code)
+			if err != nil {
+				t.Fatalf("ParseCRS(%q) returned %v", tt.code, err)
+			}
+
+			// Oslo in EPSG:25833
+			response := models.WFSResponse{Features: []models.ForestRoad{{}}}
+			response.Features[0].Geometry.Coordinates = [][]float64{{262409.732, 6649017.749}}
+			Reproject(&response, crs)
+
+			got := response.Features[0].Geometry.Coordinates[0]
+			if math.Abs(got[0]-tt.want[0]) > 0.01 || math.Abs(got[1]-tt.want[1]) > 0.01 {
+				t.Errorf("Reproject(%s) = %v, want %v", tt.code, got, tt.want)
+			}
+			if response.Crs.Properties.Name != crs.URN() {
+				t.Errorf("Reproject(%s) set the CRS %s, want %s", tt.code, response.Crs.Properties.Name, crs.URN())
+			}
+		})
+	}
+}
+
+func TestSetFeatureIDs(t *testing.T) {
+	road := func(coordinates ...[]float64) models.ForestRoad {
+		var road models.ForestRoad
+		road.Properties.Vegnummer, road.Properties.Strekningnummer, road.Properties.Delstrekningnummer = "1234", "1", "2"
+		road.Geometry.Coordinates = coordinates
+		return road
+	}
+	first := road([]float64{262100, 6649100}, []float64{262200, 6649200}, []float64{262300, 6649300})
+	second := road([]float64{262050, 6649300}, []float64{262200, 6649200}, []float64{262400, 6649100})
+
+	roads := []models.ForestRoad{first, second}
+	SetFeatureIDs(roads)
+	if !strings.HasPrefix(roads[0].ID, "262500_6649500_1234_1_2_") {
+		t.Errorf("SetFeatureIDs() id = %s, want the square and numbers first", roads[0].ID)
+	}
+	if roads[0].ID == roads[1].ID {
+		t.Errorf("SetFeatureIDs() gave roads with the same numbers in the same square the id %s", roads[0].ID)
+	}
+
+	// The id of a road does not depend on the other roads found by the query
+	for _, other := range [][]models.ForestRoad{{second, first}, {second}} {
+		SetFeatureIDs(other)
+		for _, got := range other {
+			if got.ID != roads[0].ID && got.ID != roads[1].ID {
+				t.Errorf("SetFeatureIDs() id = %s in another query, want %s or %s", got.ID, roads[0].ID, roads[1].ID)
+			}
+		}
+		if other[0].ID != roads[1].ID {
+			t.Errorf("SetFeatureIDs() id = %s in another query, want %s", other[0].ID, roads[1].ID)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package roadstore
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readGML reads the features of a GML file, ex: a GeoNorge download or a WFS GetFeature response.
+// Every child of a featureMember or member element is a feature. Its simple elements are read as properties,
+// and its LineString and Curve geometries as lines, the segments of a Curve are joined into one line.
+func readGML(path string) ([]rawRoad, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	decoder := xml.NewDecoder(file)
+
+	var rawRoads []rawRoad
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode GML: %v", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || !isFeatureMember(start.Name.Local) {
+			continue
+		}
+
+		// A featureMembers element holds many features, featureMember and member hold one
+		for {
+			road, err := readGMLFeature(decoder)
+			if err != nil {
+				return nil, err
+			}
+			if road == nil {
+				break
+			}
+			if len(road.lines) > 0 {
+				rawRoads = append(rawRoads, *road)
+			}
+		}
+	}
+
+	return rawRoads, nil
+}
+
+func isFeatureMember(name string) bool {
+	return name == "featureMember" || name == "featureMembers" || name == "member"
+}
+
+// readGMLFeature reads the next feature in a member element, or returns nil at the end of the member element.
+func readGMLFeature(decoder *xml.Decoder) (*rawRoad, error) {
+	// Find the feature element
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode GML feature: %v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			return readGMLFeatureElement(decoder)
+		case xml.EndElement:
+			if isFeatureMember(t.Name.Local) {
+				return nil, nil
+			}
+		}
+	}
+}
+
+// readGMLFeatureElement reads the content of a feature element, until its end element.
+func readGMLFeatureElement(decoder *xml.Decoder) (*rawRoad, error) {
+	road := &rawRoad{properties: make(map[string]interface{})}
+
+	depth := 1
+	var text strings.Builder
+	var line []float64
+	srsDimension := 2
+	inCurve := false
+	hasChildren := false
+
+	for depth > 0 {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode GML feature: %v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			text.Reset()
+			hasChildren = false
+
+			for _, attr := range t.Attr {
+				switch attr.Name.Local {
+				case "srsName":
+					err = checkCRS(attr.Value)
+					if err != nil {
+						return nil, err
+					}
+				case "srsDimension":
+					srsDimension, err = strconv.Atoi(attr.Value)
+					if err != nil || srsDimension < 2 {
+						return nil, fmt.Errorf("invalid srsDimension %s", attr.Value)
+					}
+				}
+			}
+
+			switch t.Name.Local {
+			case "Curve":
+				inCurve = true
+				line = nil
+			case "LineString":
+				line = nil
+			}
+
+		case xml.CharData:
+			text.Write(t)
+
+		case xml.EndElement:
+			depth--
+			value := strings.TrimSpace(text.String())
+
+			switch t.Name.Local {
+			case "posList", "pos":
+				coordinates, err := parsePositions(value, srsDimension)
+				if err != nil {
+					return nil, err
+				}
+				line = append(line, coordinates...)
+			case "coordinates":
+				coordinates, err := parseGMLCoordinates(value)
+				if err != nil {
+					return nil, err
+				}
+				line = append(line, coordinates...)
+			case "LineStringSegment":
+				// Segments share their end points, drop the repeated start of the next segment
+				line = dedupeJoints(line)
+			case "LineString":
+				if !inCurve {
+					road.lines = append(road.lines, toCoordinates(dedupeJoints(line)))
+				}
+			case "Curve":
+				road.lines = append(road.lines, toCoordinates(dedupeJoints(line)))
+				inCurve = false
+			default:
+				// Only elements without children are properties, ex: <app:vegnummer>12</app:vegnummer>
+				if depth == 1 && !hasChildren && !strings.HasPrefix(t.Name.Space, "http://www.opengis.net/gml") && value != "" {
+					road.properties[t.Name.Local] = value
+				}
+			}
+
+			text.Reset()
+			hasChildren = true
+		}
+	}
+
+	return road, nil
+}
+
+// parsePositions parses a space separated list of numbers, srsDimension numbers per position.
+func parsePositions(value string, srsDimension int) ([]float64, error) {
+	fields := strings.Fields(value)
+	if len(fields)%srsDimension != 0 {
+		return nil, fmt.Errorf("position list has %d numbers, not a multiple of %d", len(fields), srsDimension)
+	}
+
+	var positions []float64
+	for i := 0; i < len(fields); i += srsDimension {
+		x, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid coordinate %s", fields[i])
+		}
+		y, err := strconv.ParseFloat(fields[i+1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid coordinate %s", fields[i+1])
+		}
+		positions = append(positions, x, y)
+	}
+
+	return positions, nil
+}
+
+// parseGMLCoordinates parses the old GML 2 format, "x1,y1 x2,y2".
+func parseGMLCoordinates(value string) ([]float64, error) {
+	var positions []float64
+	for _, tuple := range strings.Fields(value) {
+		parts := strings.Split(tuple, ",")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid coordinate tuple %s", tuple)
+		}
+		for _, part := range parts[:2] {
+			number, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid coordinate %s", part)
+			}
+			positions = append(positions, number)
+		}
+	}
+	return positions, nil
+}
+
+// dedupeJoints removes positions that repeat the position before them.
+func dedupeJoints(flatCoords []float64) []float64 {
+	deduped := make([]float64, 0, len(flatCoords))
+	for i := 0; i+1 < len(flatCoords); i += 2 {
+		n := len(deduped)
+		if n >= 2 && deduped[n-2] == flatCoords[i] && deduped[n-1] == flatCoords[i+1] {
+			continue
+		}
+		deduped = append(deduped, flatCoords[i], flatCoords[i+1])
+	}
+	return deduped
+}
+
+func toCoordinates(flatCoords []float64) [][]float64 {
+	return flatToCoordinates(flatCoords, 2)
+}
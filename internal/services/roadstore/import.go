@@ -0,0 +1,322 @@
+package roadstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"skogkursbachelor/server/internal/models"
+	"skogkursbachelor/server/internal/services/superficialdeposits"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-shapefile"
+)
+
+// rawRoad is a feature read from a dataset, before it is converted to forestry roads.
+// A feature with a multi line geometry becomes one road per line.
+type rawRoad struct {
+	properties map[string]interface{}
+	lines      [][][]float64
+}
+
+// _propertyFields are the properties read from the dataset, keyed by their name in the WFS.
+// Shapefile field names are cut to 10 characters, so they are matched by prefix.
+var _propertyFields = map[string]func(*models.ForestRoadProperties) *string{
+	"kommunenummer":      func(p *models.ForestRoadProperties) *string { return &p.Kommunenummer },
+	"vegkategori":        func(p *models.ForestRoadProperties) *string { return &p.Vegkategori },
+	"vegfase":            func(p *models.ForestRoadProperties) *string { return &p.Vegfase },
+	"vegnummer":          func(p *models.ForestRoadProperties) *string { return &p.Vegnummer },
+	"strekningnummer":    func(p *models.ForestRoadProperties) *string { return &p.Strekningnummer },
+	"delstrekningnummer": func(p *models.ForestRoadProperties) *string { return &p.Delstrekningnummer },
+	"frameter":           func(p *models.ForestRoadProperties) *string { return &p.Frameter },
+	"tilmeter":           func(p *models.ForestRoadProperties) *string { return &p.Tilmeter },
+}
+
+// Import reads a forestry road dataset, and replaces the dataset in the store in the data directory with it.
+// The file can be GeoJSON (.geojson, .json), a Shapefile (.shp, or .zip) or GML (.gml), in EPSG:25833.
+// Superficial deposits never change, so they are looked up once here, and not for every request.
+// Returns the number of roads imported.
+func Import(ctx context.Context, path, dataDir string) (int, error) {
+	if _store != nil {
+		return 0, fmt.Errorf("the forestry road store is open for reading, stop the server before importing")
+	}
+
+	roads, err := ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	log.Info().Msgf("Read %d roads from %s, looking up superficial deposits...", len(roads), path)
+
+	err = updateSuperficialDeposits(ctx, roads)
+	if err != nil {
+		return 0, err
+	}
+
+	err = replaceStore(dataDir, roads, filepath.Base(path))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(roads), nil
+}
+
+// ReadFile reads the forestry roads of a dataset, in any format Import supports, without storing them.
+func ReadFile(path string) ([]models.ForestRoad, error) {
+	rawRoads, err := readDataset(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return toForestRoads(rawRoads)
+}
+
+// readDataset reads the features of the dataset, choosing the format by the file extension.
+func readDataset(path string) ([]rawRoad, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".geojson", ".json":
+		return readGeoJSON(path)
+	case ".shp":
+		sf, err := shapefile.Read(strings.TrimSuffix(path, filepath.Ext(path)), nil)
+		if err != nil {
+			return nil, err
+		}
+		return readShapefile(sf)
+	case ".zip":
+		sf, err := shapefile.ReadZipFile(path, nil)
+		if err != nil {
+			return nil, err
+		}
+		return readShapefile(sf)
+	case ".gml":
+		return readGML(path)
+	default:
+		return nil, fmt.Errorf("unsupported file %s, use GeoJSON, Shapefile or GML", path)
+	}
+}
+
+// readGeoJSON reads a GeoJSON FeatureCollection of LineStrings or MultiLineStrings.
+func readGeoJSON(path string) ([]rawRoad, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var collection struct {
+		Crs struct {
+			Properties struct {
+				Name string `json:"name"`
+			} `json:"properties"`
+		} `json:"crs"`
+		Features []struct {
+			Properties map[string]interface{} `json:"properties"`
+			Geometry   struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"features"`
+	}
+	err = json.NewDecoder(file).Decode(&collection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode GeoJSON: %v", err)
+	}
+
+	err = checkCRS(collection.Crs.Properties.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	rawRoads := make([]rawRoad, 0, len(collection.Features))
+	for i, feature := range collection.Features {
+		road := rawRoad{properties: feature.Properties}
+
+		switch feature.Geometry.Type {
+		case "LineString":
+			var line [][]float64
+			err = json.Unmarshal(feature.Geometry.Coordinates, &line)
+			road.lines = [][][]float64{line}
+		case "MultiLineString":
+			err = json.Unmarshal(feature.Geometry.Coordinates, &road.lines)
+		default:
+			log.Debug().Msgf("Skipping feature %d with geometry %s", i, feature.Geometry.Type)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode geometry of feature %d: %v", i, err)
+		}
+
+		rawRoads = append(rawRoads, road)
+	}
+
+	return rawRoads, nil
+}
+
+// readShapefile reads the polylines of a shapefile, which are read as MultiLineStrings.
+func readShapefile(sf *shapefile.Shapefile) ([]rawRoad, error) {
+	rawRoads := make([]rawRoad, 0, sf.NumRecords())
+	for i := 0; i < sf.NumRecords(); i++ {
+		attributes, geometry := sf.Record(i)
+
+		road := rawRoad{properties: attributes}
+		switch g := geometry.(type) {
+		case *geom.LineString:
+			road.lines = append(road.lines, flatToCoordinates(g.FlatCoords(), g.Stride()))
+		case *geom.MultiLineString:
+			for j := 0; j < g.NumLineStrings(); j++ {
+				line := g.LineString(j)
+				road.lines = append(road.lines, flatToCoordinates(line.FlatCoords(), line.Stride()))
+			}
+		default:
+			log.Debug().Msgf("Skipping record %d with geometry %T", i, geometry)
+			continue
+		}
+
+		rawRoads = append(rawRoads, road)
+	}
+
+	return rawRoads, nil
+}
+
+// flatToCoordinates splits flat coordinates into 2D coordinates, dropping Z and M values.
+func flatToCoordinates(flatCoords []float64, stride int) [][]float64 {
+	coordinates := make([][]float64, 0, len(flatCoords)/stride)
+	for i := 0; i+1 < len(flatCoords); i += stride {
+		coordinates = append(coordinates, []float64{flatCoords[i], flatCoords[i+1]})
+	}
+	return coordinates
+}
+
+// checkCRS checks that a CRS name, if the dataset has one, is EPSG:25833.
+// ex: urn:ogc:def:crs:EPSG::25833, EPSG:25833, http://www.opengis.net/def/crs/EPSG/0/25833
+func checkCRS(name string) error {
+	if name == "" || strings.HasSuffix(name, ":25833") || strings.HasSuffix(name, "/25833") {
+		return nil
+	}
+	return fmt.Errorf("unsupported CRS %s, the dataset must be in EPSG:25833", name)
+}
+
+// toForestRoads converts the features to forestry roads, with the WFS properties and one road per line.
+func toForestRoads(rawRoads []rawRoad) ([]models.ForestRoad, error) {
+	var roads []models.ForestRoad
+	for _, rawRoad := range rawRoads {
+		var properties models.ForestRoadProperties
+		for key, value := range rawRoad.properties {
+			SetProperty(&properties, key, value)
+		}
+
+		for _, line := range rawRoad.lines {
+			if len(line) < 2 {
+				continue
+			}
+
+			// Coordinates in degrees are a dataset in the wrong CRS
+			if math.Abs(line[0][0]) <= 180 && math.Abs(line[0][1]) <= 90 {
+				return nil, fmt.Errorf("coordinates look like degrees, the dataset must be in EPSG:25833")
+			}
+
+			road := models.ForestRoad{Type: "Feature", Properties: properties}
+			road.Geometry.Type = "LineString"
+			road.Geometry.Coordinates = line
+			roads = append(roads, road)
+		}
+	}
+
+	if len(roads) == 0 {
+		return nil, fmt.Errorf("no roads found in the dataset")
+	}
+
+	return roads, nil
+}
+
+// SetProperty sets a property read from a dataset, if it is one of the WFS properties.
+// Returns false for other properties.
+func SetProperty(properties *models.ForestRoadProperties, key string, value interface{}) bool {
+	field := propertyField(key)
+	if field == nil {
+		return false
+	}
+
+	*field(properties) = propertyString(value)
+	return true
+}
+
+// propertyField returns the field of the property, or nil if it is not a WFS property.
+func propertyField(key string) func(*models.ForestRoadProperties) *string {
+	key = strings.ToLower(key)
+	if field, ok := _propertyFields[key]; ok {
+		return field
+	}
+
+	// Shapefile field names are at most 10 characters, ex: kommunenum
+	if len(key) == 10 {
+		for name, field := range _propertyFields {
+			if strings.HasPrefix(name, key) {
+				return field
+			}
+		}
+	}
+
+	return nil
+}
+
+// propertyString formats a property value as the WFS does, as a string.
+func propertyString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// updateSuperficialDeposits looks up the superficial deposits of every road concurrently.
+// Roads that fail are logged, and looked up again when they are requested.
+func updateSuperficialDeposits(ctx context.Context, roads []models.ForestRoad) error {
+	semaphore := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+	var done, failed atomic.Int64
+
+	for i := range roads {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		}
+
+		wg.Add(1)
+		go func(road *models.ForestRoad) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			err := superficialdeposits.UpdateRoadSuperficialDeposits(ctx, road)
+			if err != nil {
+				log.Warn().Msg("Failed to get superficial deposit codes: " + err.Error())
+				failed.Add(1)
+			}
+
+			if n := done.Add(1); n%10000 == 0 {
+				log.Info().Msgf("Looked up superficial deposits for %d of %d roads", n, len(roads))
+			}
+		}(&roads[i])
+	}
+	wg.Wait()
+
+	if failed.Load() > 0 {
+		log.Warn().Msgf("Failed to get superficial deposits for %d of %d roads", failed.Load(), len(roads))
+	}
+
+	return ctx.Err()
+}
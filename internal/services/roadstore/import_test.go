@@ -0,0 +1,270 @@
+package roadstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"skogkursbachelor/server/internal/models"
+	"slices"
+	"testing"
+)
+
+// wantRoad is a road expected to be read from a dataset.
+type wantRoad struct {
+	kommunenummer, vegnummer, frameter string
+	coordinates                        [][]float64
+}
+
+// The roads of every test dataset, a LineString and the two lines of a MultiLineString
+var _testDatasetRoads = []wantRoad{
+	{kommunenummer: "3401", vegnummer: "1", frameter: "0", coordinates: [][]float64{{262000, 6649000}, {262500, 6649500}}},
+	{kommunenummer: "5001", vegnummer: "2", frameter: "10", coordinates: [][]float64{{-5000, 6655000}, {5000, 6655000}}},
+	{kommunenummer: "5001", vegnummer: "2", frameter: "10", coordinates: [][]float64{{6000, 6656000}, {7000, 6657000}, {8000, 6656000}}},
+}
+
+const _testGeoJSON = `{
+  "type": "FeatureCollection",
+  "crs": {"type": "name", "properties": {"name": "urn:ogc:def:crs:EPSG::25833"}},
+  "features": [
+    {"type": "Feature", "properties": {"kommunenummer": "3401", "vegnummer": 1, "frameter": 0, "eier": "Ola"},
+     "geometry": {"type": "LineString", "coordinates": [[262000, 6649000], [262500, 6649500]]}},
+    {"type": "Feature", "properties": {"kommunenummer": "5001", "vegnummer": "2", "frameter": "10"},
+     "geometry": {"type": "MultiLineString", "coordinates": [[[-5000, 6655000], [5000, 6655000]], [[6000, 6656000], [7000, 6657000], [8000, 6656000]]]}},
+    {"type": "Feature", "properties": {"vegnummer": "3"}, "geometry": {"type": "Point", "coordinates": [262000, 6649000]}}
+  ]
+}`
+
+// A GML 3.2 feature with a LineString, and a GML 2 feature with two lines, one a Curve of two segments
+const _testGML = `<?xml version="1.0" encoding="UTF-8"?>
+<wfs:FeatureCollection xmlns:wfs="http://www.opengis.net/wfs/2.0" xmlns:gml="http://www.opengis.net/gml/3.2" xmlns:app="http://skjema.geonorge.no/SOSI/produktspesifikasjon/Skogsbilveger">
+  <wfs:member>
+    <app:Skogsbilveg gml:id="1">
+      <app:kommunenummer>3401</app:kommunenummer>
+      <app:vegnummer>1</app:vegnummer>
+      <app:frameter>0</app:frameter>
+      <app:senterlinje>
+        <gml:LineString srsName="urn:ogc:def:crs:EPSG::25833" srsDimension="3">
+          <gml:posList>262000 6649000 100 262500 6649500 110</gml:posList>
+        </gml:LineString>
+      </app:senterlinje>
+    </app:Skogsbilveg>
+  </wfs:member>
+  <wfs:member>
+    <app:Skogsbilveg gml:id="2">
+      <app:kommunenummer>5001</app:kommunenummer>
+      <app:vegnummer>2</app:vegnummer>
+      <app:frameter>10</app:frameter>
+      <app:senterlinje>
+        <gml:MultiCurve srsName="EPSG:25833">
+          <gml:curveMember>
+            <gml:LineString><gml:coordinates>-5000,6655000 5000,6655000</gml:coordinates></gml:LineString>
+          </gml:curveMember>
+          <gml:curveMember>
+            <gml:Curve>
+              <gml:segments>
+                <gml:LineStringSegment><gml:posList>6000 6656000 7000 6657000</gml:posList></gml:LineStringSegment>
+                <gml:LineStringSegment><gml:posList>7000 6657000 8000 6656000</gml:posList></gml:LineStringSegment>
+              </gml:segments>
+            </gml:Curve>
+          </gml:curveMember>
+        </gml:MultiCurve>
+      </app:senterlinje>
+    </app:Skogsbilveg>
+  </wfs:member>
+</wfs:FeatureCollection>
+`
+
+func TestReadFile(t *testing.T) {
+	dir := t.TempDir()
+	geoJSONPath := filepath.Join(dir, "roads.geojson")
+	gmlPath := filepath.Join(dir, "roads.gml")
+	for path, content := range map[string]string{geoJSONPath: _testGeoJSON, gmlPath: _testGML} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	shapefilePath := writeTestShapefile(t, []testPolyline{
+		{
+			fields: [3]string{"3401", "1", "0"},
+			parts:  [][][2]float64{{{262000, 6649000}, {262500, 6649500}}},
+		},
+		{
+			fields: [3]string{"5001", "2", "10"},
+			parts: [][][2]float64{
+				{{-5000, 6655000}, {5000, 6655000}},
+				{{6000, 6656000}, {7000, 6657000}, {8000, 6656000}},
+			},
+		},
+	})
+
+	for _, path := range []string{geoJSONPath, gmlPath, shapefilePath} {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			roads, err := ReadFile(path)
+			if err != nil {
+				t.Fatalf("ReadFile returned %v", err)
+			}
+
+			if len(roads) != len(_testDatasetRoads) {
+				t.Fatalf("ReadFile returned %d roads, want %d", len(roads), len(_testDatasetRoads))
+			}
+			for i, want := range _testDatasetRoads {
+				checkRoad(t, roads[i], want)
+			}
+		})
+	}
+}
+
+func TestReadFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name:    "wrong CRS",
+			file:    "roads.geojson",
+			content: `{"crs": {"properties": {"name": "EPSG:4326"}}, "features": []}`,
+		},
+		{
+			name: "degrees",
+			file: "roads.geojson",
+			content: `{"features": [{"properties": {}, "geometry": {"type": "LineString",` +
+				` "coordinates": [[10.7, 59.9], [10.8, 59.9]]}}]}`,
+		},
+		{
+			name:    "no roads",
+			file:    "roads.geojson",
+			content: `{"features": []}`,
+		},
+		{
+			name: "wrong GML CRS",
+			file: "roads.gml",
+			content: `<FeatureCollection><member><Road><LineString srsName="EPSG:3857">` +
+				`<posList>0 0 1 1</posList></LineString></Road></member></FeatureCollection>`,
+		},
+		{
+			name:    "unknown format",
+			file:    "roads.kml",
+			content: `<kml/>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := ReadFile(path); err == nil {
+				t.Errorf("ReadFile returned no error")
+			}
+		})
+	}
+}
+
+func checkRoad(t *testing.T, road models.ForestRoad, want wantRoad) {
+	t.Helper()
+
+	properties := road.Properties
+	if properties.Kommunenummer != want.kommunenummer || properties.Vegnummer != want.vegnummer ||
+		properties.Frameter != want.frameter {
+		t.Errorf("properties = %s, %s, %s, want %s, %s, %s", properties.Kommunenummer, properties.Vegnummer,
+			properties.Frameter, want.kommunenummer, want.vegnummer, want.frameter)
+	}
+
+	if road.Type != "Feature" || road.Geometry.Type != "LineString" {
+		t.Errorf("type = %s %s, want a Feature LineString", road.Type, road.Geometry.Type)
+	}
+	if !slices.EqualFunc(road.Geometry.Coordinates, want.coordinates, slices.Equal[[]float64]) {
+		t.Errorf("coordinates = %v, want %v", road.Geometry.Coordinates, want.coordinates)
+	}
+}
+
+// testPolyline is a shapefile polyline record with the kommunenummer, vegnummer and frameter fields.
+type testPolyline struct {
+	fields [3]string
+	parts  [][][2]float64
+}
+
+// writeTestShapefile writes a minimal .shp and .dbf of polylines, and returns the path of the .shp.
+// The field names are cut to 10 characters, as in real shapefiles.
+func writeTestShapefile(t *testing.T, polylines []testPolyline) string {
+	t.Helper()
+
+	basename := filepath.Join(t.TempDir(), "roads")
+
+	var records bytes.Buffer
+	for i, polyline := range polylines {
+		var parts []int32
+		var points [][2]float64
+		for _, part := range polyline.parts {
+			parts = append(parts, int32(len(points)))
+			points = append(points, part...)
+		}
+
+		minX, minY, maxX, maxY := bounds(toFloatCoordinates(points))
+		var content bytes.Buffer
+		writeLE(&content, int32(3), minX, minY, maxX, maxY, int32(len(parts)), int32(len(points)), parts, points)
+
+		writeBE(&records, int32(i+1), int32(content.Len()/2))
+		records.Write(content.Bytes())
+	}
+
+	var shp bytes.Buffer
+	writeBE(&shp, int32(9994), [5]int32{}, int32((100+records.Len())/2))
+	writeLE(&shp, int32(1000), int32(3), [4]float64{}, [4]float64{})
+	shp.Write(records.Bytes())
+
+	if err := os.WriteFile(basename+".shp", shp.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	const fieldLength = 8
+	names := []string{"kommunenum", "vegnummer", "frameter"}
+	var dbf bytes.Buffer
+	writeLE(&dbf, byte(3), [3]byte{125, 1, 1}, int32(len(polylines)), int16(32+32*len(names)+1),
+		int16(1+fieldLength*len(names)), [20]byte{})
+	for _, fieldName := range names {
+		var name [11]byte
+		copy(name[:], fieldName)
+		writeLE(&dbf, name, byte('C'), [4]byte{}, byte(fieldLength), byte(0), [14]byte{})
+	}
+	dbf.WriteByte(0x0d)
+	for _, polyline := range polylines {
+		dbf.WriteByte(' ')
+		for _, field := range polyline.fields {
+			dbf.WriteString(fmt.Sprintf("%-*s", fieldLength, field))
+		}
+	}
+	dbf.WriteByte(0x1a)
+
+	if err := os.WriteFile(basename+".dbf", dbf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return basename + ".shp"
+}
+
+func toFloatCoordinates(points [][2]float64) [][]float64 {
+	coordinates := make([][]float64, len(points))
+	for i, point := range points {
+		coordinates[i] = []float64{point[0], point[1]}
+	}
+	return coordinates
+}
+
+func writeLE(buf *bytes.Buffer, values ...interface{}) {
+	for _, value := range values {
+		_ = binary.Write(buf, binary.LittleEndian, value)
+	}
+}
+
+func writeBE(buf *bytes.Buffer, values ...interface{}) {
+	for _, value := range values {
+		_ = binary.Write(buf, binary.BigEndian, value)
+	}
+}
@@ -0,0 +1,360 @@
+// Package roadstore keeps a local copy of the national forestry road dataset, so forestry roads can be served
+// without the GeoNorge WFS. Roads are imported from a download, see Import, and queried by bounding box.
+package roadstore
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"skogkursbachelor/server/internal/models"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// _store is the open road store, it is nil until InitStore is called
+var _store *bolt.DB
+
+// _storeFile is the name of the database file in the data directory
+const _storeFile = "forestryroads.db"
+
+// _cellSize is the size in meters of the square grid cells roads are indexed by
+const _cellSize = 10000
+
+// _maxQueryCells is the most grid cells a query may read, a 200 km square
+const _maxQueryCells = 400
+
+// Buckets of the store
+var (
+	// _roadsBucket maps the id of a road to the road, encoded as JSON
+	_roadsBucket = []byte("roads")
+	// _cellsBucket holds a key per grid cell and road in it, the cell followed by the road id
+	_cellsBucket = []byte("cells")
+	// _metaBucket holds information about the import
+	_metaBucket = []byte("meta")
+)
+
+// Info describes the imported dataset.
+type Info struct {
+	Source   string    `json:"source"`
+	Roads    int       `json:"roads"`
+	Imported time.Time `json:"imported"`
+}
+
+// InitStore opens the road store in the data directory. The store must have been created by Import.
+func InitStore(dataDir string) error {
+	db, err := openStore(dataDir)
+	if err != nil {
+		return err
+	}
+
+	_store = db
+
+	info, err := GetInfo()
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("Forestry road store opened, %d roads imported from %s at %s", info.Roads, info.Source, info.Imported)
+	return nil
+}
+
+// Enabled checks if forestry roads are served from the local store.
+func Enabled() bool {
+	return _store != nil
+}
+
+// GetInfo returns information about the imported dataset.
+func GetInfo() (Info, error) {
+	var info Info
+	err := _store.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(_metaBucket)
+		if bucket == nil {
+			return fmt.Errorf("the store has no imported dataset")
+		}
+		return json.Unmarshal(bucket.Get([]byte("info")), &info)
+	})
+	return info, err
+}
+
+// openStore opens the database in the data directory for reading, it must exist.
+func openStore(dataDir string) (*bolt.DB, error) {
+	if dataDir == "" {
+		return nil, fmt.Errorf("no data directory for the forestry road store")
+	}
+
+	path := filepath.Join(dataDir, _storeFile)
+	_, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("no forestry road store at %s, import a dataset first: %v", path, err)
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+
+	return db, nil
+}
+
+// replaceStore writes the roads to a new database next to the store in the data directory, and moves it over
+// the store once every road is written, so a failed import leaves the previous dataset in place.
+func replaceStore(dataDir string, roads []models.ForestRoad, source string) error {
+	if dataDir == "" {
+		return fmt.Errorf("no data directory for the forestry road store")
+	}
+
+	err := os.MkdirAll(dataDir, 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	// The lock on the new database keeps two imports apart, write clears what an interrupted import left
+	path := filepath.Join(dataDir, _storeFile)
+	importPath := path + ".import"
+	db, err := bolt.Open(importPath, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", importPath, err)
+	}
+
+	err = write(db, roads, source)
+	closeErr := db.Close()
+	if err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close %s: %v", importPath, closeErr)
+	}
+	if err != nil {
+		_ = os.Remove(importPath)
+		return err
+	}
+
+	err = os.Rename(importPath, path)
+	if err != nil {
+		_ = os.Remove(importPath)
+		return fmt.Errorf("failed to replace %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// Query returns every road with a bounding box that intersects the bounding box, in EPSG:25833 meters.
+func Query(minX, minY, maxX, maxY float64) ([]models.ForestRoad, error) {
+	if _store == nil {
+		return nil, fmt.Errorf("the forestry road store is not open")
+	}
+
+	for _, value := range []float64{minX, minY, maxX, maxY} {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return nil, fmt.Errorf("bbox %v,%v,%v,%v is not finite", minX, minY, maxX, maxY)
+		}
+	}
+	// Counted before converting to cells, which would overflow for a large bbox
+	cellCount := (math.Floor(maxX/_cellSize) - math.Floor(minX/_cellSize) + 1) *
+		(math.Floor(maxY/_cellSize) - math.Floor(minY/_cellSize) + 1)
+	if cellCount > _maxQueryCells {
+		return nil, fmt.Errorf("bbox %v,%v,%v,%v covers more than %d grid cells", minX, minY, maxX, maxY, _maxQueryCells)
+	}
+
+	roads := []models.ForestRoad{}
+	err := _store.View(func(tx *bolt.Tx) error {
+		cells := tx.Bucket(_cellsBucket)
+		roadsBucket := tx.Bucket(_roadsBucket)
+		if cells == nil || roadsBucket == nil {
+			return fmt.Errorf("the store has no imported dataset")
+		}
+
+		// A road is indexed in every cell it touches, only read it once
+		seen := make(map[uint64]bool)
+
+		minCellX, minCellY := cellOf(minX, minY)
+		maxCellX, maxCellY := cellOf(maxX, maxY)
+		cursor := cells.Cursor()
+		for cellX := minCellX; cellX <= maxCellX; cellX++ {
+			for cellY := minCellY; cellY <= maxCellY; cellY++ {
+				prefix := cellKey(cellX, cellY)
+				for key, _ := cursor.Seek(prefix); key != nil && hasPrefix(key, prefix); key, _ = cursor.Next() {
+					id := binary.BigEndian.Uint64(key[len(prefix):])
+					if seen[id] {
+						continue
+					}
+					seen[id] = true
+
+					var road models.ForestRoad
+					err := json.Unmarshal(roadsBucket.Get(roadKey(id)), &road)
+					if err != nil {
+						return fmt.Errorf("failed to decode road %d: %v", id, err)
+					}
+
+					roadMinX, roadMinY, roadMaxX, roadMaxY := bounds(road.Geometry.Coordinates)
+					if roadMaxX < minX || roadMinX > maxX || roadMaxY < minY || roadMinY > maxY {
+						continue
+					}
+
+					roads = append(roads, road)
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return roads, err
+}
+
+// All returns every road in the store.
+func All() ([]models.ForestRoad, error) {
+	if _store == nil {
+		return nil, fmt.Errorf("the forestry road store is not open")
+	}
+
+	var roads []models.ForestRoad
+	err := _store.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(_roadsBucket)
+		if bucket == nil {
+			return fmt.Errorf("the store has no imported dataset")
+		}
+
+		return bucket.ForEach(func(key, value []byte) error {
+			var road models.ForestRoad
+			err := json.Unmarshal(value, &road)
+			if err != nil {
+				return fmt.Errorf("failed to decode road %d: %v", binary.BigEndian.Uint64(key), err)
+			}
+			roads = append(roads, road)
+			return nil
+		})
+	})
+
+	return roads, err
+}
+
+// Filter returns the roads that intersect the bbox, minx,miny,maxx,maxy in EPSG:25833, and are in the county.
+// The county is the fylkesnummer, the first two digits of the kommunenummer. A nil bbox or empty county
+// does not filter.
+func Filter(roads []models.ForestRoad, bbox []float64, county string) []models.ForestRoad {
+	var filtered []models.ForestRoad
+	for _, road := range roads {
+		if county != "" && !strings.HasPrefix(road.Properties.Kommunenummer, county) {
+			continue
+		}
+
+		if bbox != nil {
+			minX, minY, maxX, maxY := bounds(road.Geometry.Coordinates)
+			if maxX < bbox[0] || minX > bbox[2] || maxY < bbox[1] || minY > bbox[3] {
+				continue
+			}
+		}
+
+		filtered = append(filtered, road)
+	}
+	return filtered
+}
+
+// write replaces the dataset in the database with the roads.
+func write(db *bolt.DB, roads []models.ForestRoad, source string) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{_roadsBucket, _cellsBucket, _metaBucket} {
+			if tx.Bucket(name) != nil {
+				err := tx.DeleteBucket(name)
+				if err != nil {
+					return err
+				}
+			}
+			_, err := tx.CreateBucket(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clear the store: %v", err)
+	}
+
+	// Write in batches, one transaction for the whole country would hold every page in memory
+	const batchSize = 5000
+	for start := 0; start < len(roads); start += batchSize {
+		batch := roads[start:min(start+batchSize, len(roads))]
+
+		err = db.Update(func(tx *bolt.Tx) error {
+			roadsBucket := tx.Bucket(_roadsBucket)
+			cells := tx.Bucket(_cellsBucket)
+
+			for i, road := range batch {
+				id := uint64(start + i)
+				data, err := json.Marshal(road)
+				if err != nil {
+					return err
+				}
+
+				err = roadsBucket.Put(roadKey(id), data)
+				if err != nil {
+					return err
+				}
+
+				minX, minY, maxX, maxY := bounds(road.Geometry.Coordinates)
+				minCellX, minCellY := cellOf(minX, minY)
+				maxCellX, maxCellY := cellOf(maxX, maxY)
+				for cellX := minCellX; cellX <= maxCellX; cellX++ {
+					for cellY := minCellY; cellY <= maxCellY; cellY++ {
+						err = cells.Put(append(cellKey(cellX, cellY), roadKey(id)...), nil)
+						if err != nil {
+							return err
+						}
+					}
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write roads: %v", err)
+		}
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(Info{Source: source, Roads: len(roads), Imported: time.Now()})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(_metaBucket).Put([]byte("info"), data)
+	})
+}
+
+// cellOf returns the grid cell of the point.
+func cellOf(x, y float64) (int32, int32) {
+	return int32(math.Floor(x / _cellSize)), int32(math.Floor(y / _cellSize))
+}
+
+// cellKey encodes the cell so that keys of the same cell share a prefix.
+// The sign bit is flipped, so negative cells sort before positive ones.
+func cellKey(cellX, cellY int32) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint32(key[:4], uint32(cellX)^1<<31)
+	binary.BigEndian.PutUint32(key[4:], uint32(cellY)^1<<31)
+	return key
+}
+
+func roadKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+func hasPrefix(key, prefix []byte) bool {
+	return len(key) >= len(prefix) && string(key[:len(prefix)]) == string(prefix)
+}
+
+// bounds returns the bounding box of the coordinates.
+func bounds(coordinates [][]float64) (float64, float64, float64, float64) {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, coordinate := range coordinates {
+		minX, maxX = math.Min(minX, coordinate[0]), math.Max(maxX, coordinate[0])
+		minY, maxY = math.Min(minY, coordinate[1]), math.Max(maxY, coordinate[1])
+	}
+	return minX, minY, maxX, maxY
+}
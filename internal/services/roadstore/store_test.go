@@ -0,0 +1,159 @@
+package roadstore
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"skogkursbachelor/server/internal/models"
+	"slices"
+	"testing"
+)
+
+// testRoad returns a road with the vegnummer and coordinates, x, y pairs in EPSG:25833.
+func testRoad(vegnummer string, coordinates ...[]float64) models.ForestRoad {
+	road := models.ForestRoad{Type: "Feature", Properties: models.ForestRoadProperties{Vegnummer: vegnummer}}
+	road.Geometry.Type = "LineString"
+	road.Geometry.Coordinates = coordinates
+	return road
+}
+
+// openTestStore opens the store in the data directory as the active store, and closes it when the test ends.
+func openTestStore(t *testing.T, dataDir string) {
+	t.Helper()
+
+	if err := InitStore(dataDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_store.Close()
+		_store = nil
+	})
+}
+
+func TestReplaceStore(t *testing.T) {
+	dataDir := t.TempDir()
+	road := testRoad("1", []float64{262000, 6649000}, []float64{262500, 6649500})
+	if err := replaceStore(dataDir, []models.ForestRoad{road}, "first.geojson"); err != nil {
+		t.Fatalf("replaceStore returned %v", err)
+	}
+
+	// NaN can not be encoded, so the import fails after some roads are written
+	broken := testRoad("2", []float64{262000, 6649000}, []float64{math.NaN(), 6649500})
+	err := replaceStore(dataDir, []models.ForestRoad{road, road, broken}, "second.geojson")
+	if err == nil {
+		t.Fatalf("replaceStore with a broken road returned no error")
+	}
+
+	if _, err := os.Stat(filepath.Join(dataDir, _storeFile+".import")); !os.IsNotExist(err) {
+		t.Errorf("the failed import left its database behind: %v", err)
+	}
+
+	openTestStore(t, dataDir)
+	info, err := GetInfo()
+	if err != nil || info.Source != "first.geojson" || info.Roads != 1 {
+		t.Errorf("GetInfo() = %+v, %v, want the first import of 1 road", info, err)
+	}
+}
+
+func TestCellKeyOrder(t *testing.T) {
+	cells := []int32{math.MinInt32, -100, -2, -1, 0, 1, 2, 100, math.MaxInt32}
+
+	for i := 1; i < len(cells); i++ {
+		for _, keys := range [][2][]byte{
+			{cellKey(cells[i-1], 0), cellKey(cells[i], 0)},
+			{cellKey(0, cells[i-1]), cellKey(0, cells[i])},
+		} {
+			if bytes.Compare(keys[0], keys[1]) >= 0 {
+				t.Errorf("cell key of %d does not sort before the cell key of %d", cells[i-1], cells[i])
+			}
+		}
+	}
+
+	// Every road of a cell follows the cell key, and sorts before the next cell
+	key := append(cellKey(-1, 5), roadKey(math.MaxUint64)...)
+	if !hasPrefix(key, cellKey(-1, 5)) || bytes.Compare(key, cellKey(-1, 6)) >= 0 {
+		t.Errorf("road key %x is not within cell -1, 5", key)
+	}
+}
+
+func TestCellOf(t *testing.T) {
+	tests := []struct {
+		x, y         float64
+		cellX, cellY int32
+	}{
+		{x: 0, y: 0, cellX: 0, cellY: 0},
+		{x: 9999.9, y: 10000, cellX: 0, cellY: 1},
+		{x: -0.1, y: -10000, cellX: -1, cellY: -1},
+		{x: -10000.1, y: 6649000, cellX: -2, cellY: 664},
+	}
+
+	for _, tt := range tests {
+		cellX, cellY := cellOf(tt.x, tt.y)
+		if cellX != tt.cellX || cellY != tt.cellY {
+			t.Errorf("cellOf(%v, %v) = %d, %d, want %d, %d", tt.x, tt.y, cellX, cellY, tt.cellX, tt.cellY)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	dataDir := t.TempDir()
+	roads := []models.ForestRoad{
+		// Across the cells -2, -1 and 0, west of the central meridian of zone 33
+		testRoad("1", []float64{-15000, 6655000}, []float64{-5000, 6655000}, []float64{5000, 6655000}),
+		// Across the cells 26 and 27, and 664 and 665
+		testRoad("2", []float64{265000, 6645000}, []float64{275000, 6652000}),
+		// Within the cell 26, 664
+		testRoad("3", []float64{262000, 6649000}, []float64{262500, 6649500}),
+		// In the cells -1, -1 south and west of the origin
+		testRoad("4", []float64{-500, -500}, []float64{-100, -100}),
+	}
+	if err := replaceStore(dataDir, roads, "test.geojson"); err != nil {
+		t.Fatal(err)
+	}
+	openTestStore(t, dataDir)
+
+	tests := []struct {
+		name                   string
+		minX, minY, maxX, maxY float64
+		want                   []string
+	}{
+		{name: "road in many cells is returned once", minX: -20000, minY: 6650000, maxX: 10000, maxY: 6660000, want: []string{"1"}},
+		{name: "negative cell", minX: -12000, minY: 6654000, maxX: -11000, maxY: 6656000, want: []string{"1"}},
+		{name: "straddles a negative cell edge", minX: -10001, minY: 6654000, maxX: -9999, maxY: 6656000, want: []string{"1"}},
+		{name: "straddles the zero edge", minX: -1, minY: 6654000, maxX: 1, maxY: 6656000, want: []string{"1"}},
+		{name: "south and west of the origin", minX: -1000, minY: -1000, maxX: 0, maxY: 0, want: []string{"4"}},
+		{name: "straddles cell edges", minX: 269000, minY: 6649000, maxX: 271000, maxY: 6651000, want: []string{"2"}},
+		{name: "both roads of a cell", minX: 260000, minY: 6640000, maxX: 270000, maxY: 6650000, want: []string{"2", "3"}},
+		{name: "same cell, outside the roads", minX: 261000, minY: 6641000, maxX: 261500, maxY: 6641500},
+		{name: "empty area", minX: 500000, minY: 7000000, maxX: 510000, maxY: 7010000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roads, err := Query(tt.minX, tt.minY, tt.maxX, tt.maxY)
+			if err != nil {
+				t.Fatalf("Query returned %v", err)
+			}
+
+			var got []string
+			for _, road := range roads {
+				got = append(got, road.Properties.Vegnummer)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Query(%v, %v, %v, %v) = roads %v, want %v", tt.minX, tt.minY, tt.maxX, tt.maxY, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := Query(math.Inf(-1), 0, 0, 0); err == nil {
+		t.Errorf("Query with an infinite bound returned no error")
+	}
+	if _, err := Query(math.NaN(), 0, 0, 0); err == nil {
+		t.Errorf("Query with a NaN bound returned no error")
+	}
+	if _, err := Query(-1e300, -1e300, 1e300, 1e300); err == nil {
+		t.Errorf("Query of the whole plane returned no error")
+	}
+}
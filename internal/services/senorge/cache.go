@@ -0,0 +1,252 @@
+package senorge
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"skogkursbachelor/server/internal/models"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// _cache holds SeNorge values by theme, date and grid cell, it is nil until InitCache is called
+var _cache *gridCache
+
+// _cachedNoDataValue marks cached no-data values in responses built only from the cache
+const _cachedNoDataValue = -9999
+
+// cacheKey is the value of a theme on a date in a grid cell.
+type cacheKey struct {
+	theme string
+	date  string
+	point gridPoint
+}
+
+// cacheEntry is a cached value, no-data values are stored as noData instead of the sentinel of the response.
+// Final values are observations for past dates, which do not change.
+type cacheEntry struct {
+	key      cacheKey
+	value    float64
+	noData   bool
+	prognose bool
+	final    bool
+	expires  time.Time
+}
+
+// gridCache is a least recently used cache of SeNorge values.
+// Values for past dates never expire, values for today and forecasts expire after todayTTL.
+type gridCache struct {
+	mu       sync.Mutex
+	entries  map[cacheKey]*list.Element
+	order    *list.List
+	size     int
+	todayTTL time.Duration
+}
+
+// InitCache enables the SeNorge cache with room for size values. Values for today and later expire after todayTTL.
+// A size of 0 disables the cache.
+func InitCache(size int, todayTTL time.Duration) {
+	if size <= 0 {
+		_cache = nil
+		log.Info().Msg("SeNorge cache disabled")
+		return
+	}
+
+	_cache = &gridCache{
+		entries:  make(map[cacheKey]*list.Element),
+		order:    list.New(),
+		size:     size,
+		todayTTL: todayTTL,
+	}
+	log.Info().Msgf("SeNorge cache enabled with size %d, today TTL %s", size, todayTTL)
+}
+
+// getTheme returns the time series of a theme for the grid points. Points are looked up in the memory cache,
+// then in the disk store, and only the points found in neither are requested from NVE.
+// If some chunks of the request fail, the response holds the rest and the error is a *ChunkError.
+func getTheme(
+	ctx context.Context,
+	theme, startDate, endDate string,
+	points []gridPoint,
+) (models.NVEMultiPointTimeSeriesResponse, error) {
+	if _cache == nil && _store == nil {
+		return fetchTheme(ctx, theme, startDate, endDate, points)
+	}
+
+	dates, err := datesInRange(startDate, endDate)
+	if err != nil {
+		return models.NVEMultiPointTimeSeriesResponse{}, err
+	}
+
+	cached := make(map[gridPoint][]cacheEntry)
+	missing := points
+	if _cache != nil {
+		cached, missing = _cache.lookup(theme, dates, missing)
+	}
+
+	if _store != nil && len(missing) > 0 {
+		var stored map[gridPoint][]cacheEntry
+		stored, missing = _store.lookup(theme, dates, missing)
+		for point, entries := range stored {
+			cached[point] = entries
+			if _cache != nil {
+				_cache.put(entries)
+			}
+		}
+	}
+	log.Debug().Msgf("SeNorge cache %s: %d cached, %d missing", theme, len(cached), len(missing))
+
+	response := models.NVEMultiPointTimeSeriesResponse{Theme: theme, NoDataValue: _cachedNoDataValue}
+	if len(missing) > 0 {
+		response, err = fetchTheme(ctx, theme, startDate, endDate, missing)
+		var chunkErr *ChunkError
+		if err != nil && !(errors.As(err, &chunkErr) && chunkErr.Partial()) {
+			return response, err
+		}
+
+		entries := entriesFromResponse(theme, dates, response)
+		if _cache != nil {
+			_cache.put(entries)
+		}
+		if _store != nil {
+			_store.put(entries)
+		}
+	}
+
+	// Compare the forecast start as a date, ex: 2021-03-01T00:00:00 -> 2021-03-01
+	response.PrognoseStartDate = strings.Split(response.PrognoseStartDate, "T")[0]
+
+	for point, entries := range cached {
+		cell := models.CellTimeSeries{X: point.x, Y: point.y, Data: make([]float64, len(entries))}
+		for i, entry := range entries {
+			cell.Data[i] = entry.value
+			if entry.noData {
+				cell.Data[i] = float64(response.NoDataValue)
+			}
+
+			// The forecast starts on the first date with a forecast value
+			if entry.prognose && (response.PrognoseStartDate == "" || dates[i] < response.PrognoseStartDate) {
+				response.PrognoseStartDate = dates[i]
+			}
+		}
+		response.CellTimeSeries = append(response.CellTimeSeries, cell)
+	}
+
+	return response, err
+}
+
+// entriesFromResponse splits the response into one entry per date and grid cell.
+func entriesFromResponse(theme string, dates []string, response models.NVEMultiPointTimeSeriesResponse) []cacheEntry {
+	today := time.Now().Format(time.DateOnly)
+	var entries []cacheEntry
+
+	for _, cell := range response.CellTimeSeries {
+		for i, value := range cell.Data {
+			if i >= len(dates) {
+				break
+			}
+
+			entry := cacheEntry{
+				key:      cacheKey{theme: theme, date: dates[i], point: gridPoint{x: cell.X, y: cell.Y}},
+				value:    value,
+				noData:   response.IsNoData(value),
+				prognose: response.IsPrognose(dates[i]),
+			}
+
+			// Dates formatted as YYYY-MM-DD sort lexically, only past observations are final
+			entry.final = dates[i] < today && !entry.prognose
+
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// lookup returns the cached values of the points that are cached for every date, and the points that are not.
+func (cache *gridCache) lookup(theme string, dates []string, points []gridPoint) (map[gridPoint][]cacheEntry, []gridPoint) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	now := time.Now()
+	cached := make(map[gridPoint][]cacheEntry)
+	var missing []gridPoint
+
+	for _, point := range points {
+		entries := make([]cacheEntry, 0, len(dates))
+		for _, date := range dates {
+			element, ok := cache.entries[cacheKey{theme: theme, date: date, point: point}]
+			if !ok {
+				break
+			}
+
+			entry := element.Value.(*cacheEntry)
+			if !entry.expires.IsZero() && now.After(entry.expires) {
+				cache.order.Remove(element)
+				delete(cache.entries, entry.key)
+				break
+			}
+
+			cache.order.MoveToFront(element)
+			entries = append(entries, *entry)
+		}
+
+		if len(entries) == len(dates) {
+			cached[point] = entries
+		} else {
+			missing = append(missing, point)
+		}
+	}
+
+	return cached, missing
+}
+
+// put adds the entries to the cache, evicting the least recently used values when full.
+// Entries that are not final expire after todayTTL.
+func (cache *gridCache) put(entries []cacheEntry) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	expires := time.Now().Add(cache.todayTTL)
+
+	for _, entry := range entries {
+		if !entry.final {
+			entry.expires = expires
+		}
+
+		if element, ok := cache.entries[entry.key]; ok {
+			element.Value = &entry
+			cache.order.MoveToFront(element)
+			continue
+		}
+
+		cache.entries[entry.key] = cache.order.PushFront(&entry)
+		for cache.order.Len() > cache.size {
+			oldest := cache.order.Back()
+			cache.order.Remove(oldest)
+			delete(cache.entries, oldest.Value.(*cacheEntry).key)
+		}
+	}
+}
+
+// datesInRange returns every date from startDate to endDate, formatted as YYYY-MM-DD.
+func datesInRange(startDate, endDate string) ([]string, error) {
+	start, err := time.Parse(time.DateOnly, startDate)
+	if err != nil {
+		return nil, err
+	}
+	end, err := time.Parse(time.DateOnly, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	var dates []string
+	for date := start; !date.After(end); date = date.AddDate(0, 0, 1) {
+		dates = append(dates, date.Format(time.DateOnly))
+	}
+
+	return dates, nil
+}
@@ -0,0 +1,135 @@
+package senorge
+
+import (
+	"context"
+	"fmt"
+	"skogkursbachelor/server/internal/models"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// _chunkSize is the most grid points sent to NVE in one request
+var _chunkSize = 500
+
+// _chunkConcurrency is the most requests sent to NVE at the same time, per theme
+var _chunkConcurrency = 4
+
+// ConfigureChunks sets the most grid points per request, and how many requests are sent at the same time per theme.
+func ConfigureChunks(size, concurrency int) {
+	if size > 0 {
+		_chunkSize = size
+	}
+	if concurrency > 0 {
+		_chunkConcurrency = concurrency
+	}
+	log.Info().Msgf("SeNorge requests split into chunks of %d points, %d at a time", _chunkSize, _chunkConcurrency)
+}
+
+// ChunkFailure is a chunk of a request that failed.
+type ChunkFailure struct {
+	// Index of the chunk, counting from 0
+	Index int
+	// First and Last are the first and last grid point in the chunk, formatted as "X Y"
+	First, Last string
+	Points      int
+	Err         error
+}
+
+// ChunkError lists the chunks of a request that failed. The response returned with it holds the other chunks.
+type ChunkError struct {
+	Theme  string
+	Total  int
+	Failed []ChunkFailure
+}
+
+func (chunkErr *ChunkError) Error() string {
+	failures := make([]string, len(chunkErr.Failed))
+	for i, failure := range chunkErr.Failed {
+		failures[i] = fmt.Sprintf(
+			"chunk %d (%d points, %s to %s): %v",
+			failure.Index, failure.Points, failure.First, failure.Last, failure.Err,
+		)
+	}
+
+	return fmt.Sprintf(
+		"%d of %d chunks failed for %s: %s",
+		len(chunkErr.Failed), chunkErr.Total, chunkErr.Theme, strings.Join(failures, "; "),
+	)
+}
+
+// Partial checks if some of the chunks succeeded.
+func (chunkErr *ChunkError) Partial() bool {
+	return len(chunkErr.Failed) < chunkErr.Total
+}
+
+// fetchTheme requests the time series of a theme for the grid points from the NVE API.
+// The points are split into chunks that are requested concurrently, and merged into one response.
+// If some chunks fail, the response holds the other chunks and the error is a *ChunkError.
+// Chunks that are not started when the context is cancelled fail with the context error.
+func fetchTheme(
+	ctx context.Context,
+	theme, startDate, endDate string,
+	points []gridPoint,
+) (models.NVEMultiPointTimeSeriesResponse, error) {
+	var chunks [][]gridPoint
+	for start := 0; start < len(points); start += _chunkSize {
+		chunks = append(chunks, points[start:min(start+_chunkSize, len(points))])
+	}
+
+	responses := make([]models.NVEMultiPointTimeSeriesResponse, len(chunks))
+	errs := make([]error, len(chunks))
+
+	semaphore := make(chan struct{}, _chunkConcurrency)
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		// Reserve a slot, or fail the chunk if the request is abandoned
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			responses[i], errs[i] = fetchChunk(ctx, theme, startDate, endDate, chunk)
+		}()
+	}
+	wg.Wait()
+
+	merged := models.NVEMultiPointTimeSeriesResponse{}
+	chunkErr := &ChunkError{Theme: theme, Total: len(chunks)}
+	merging := false
+	for i, response := range responses {
+		if errs[i] != nil {
+			chunkErr.Failed = append(chunkErr.Failed, ChunkFailure{
+				Index:  i,
+				First:  formatGridPoints(chunks[i][:1]),
+				Last:   formatGridPoints(chunks[i][len(chunks[i])-1:]),
+				Points: len(chunks[i]),
+				Err:    errs[i],
+			})
+			continue
+		}
+
+		// The metadata is the same for every chunk, take it from the first one
+		if !merging {
+			merged = response
+			merged.CellTimeSeries = nil
+			merging = true
+		}
+		merged.CellTimeSeries = append(merged.CellTimeSeries, response.CellTimeSeries...)
+	}
+
+	if len(chunkErr.Failed) > 0 {
+		log.Warn().Msg(chunkErr.Error())
+		return merged, chunkErr
+	}
+
+	return merged, nil
+}
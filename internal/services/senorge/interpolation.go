@@ -0,0 +1,216 @@
+package senorge
+
+import (
+	"fmt"
+	"math"
+	"skogkursbachelor/server/internal/models"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// _cellSize is the size of a SeNorge grid cell in meters
+const _cellSize = 1000
+
+// _maxInterpolationDistance includes the 8 neighbouring cells, the diagonal ones are sqrt(2) * 1000 meters away
+const _maxInterpolationDistance = 1.5 * _cellSize
+
+// gridCell is a cell in a SeNorge response with data on the end date.
+type gridCell struct {
+	x, y   float64
+	value  float64
+	series []models.DailyValue
+}
+
+// findGaps returns the cluster keys where the property has no value, because the cell was missing
+// from the response or had no data.
+func findGaps(featureMap map[string][]models.ForestRoad, provider GridProvider) []string {
+	var gaps []string
+	for key, roads := range featureMap {
+		if len(roads) == 0 {
+			continue
+		}
+
+		status := roads[0].Properties.Datastatus[provider.Property()]
+		if status == models.DataStatusMissing || status == models.DataStatusNoData {
+			gaps = append(gaps, key)
+		}
+	}
+	return gaps
+}
+
+// createNeighbourGridPoints returns the neighbouring cells of the gaps that were not already requested.
+func createNeighbourGridPoints(featureMap map[string][]models.ForestRoad, gaps []string) []gridPoint {
+	seen := make(map[gridPoint]bool)
+	var points []gridPoint
+
+	for _, key := range gaps {
+		x, y, err := parseClusterKey(key)
+		if err != nil {
+			log.Warn().Msg(err.Error())
+			continue
+		}
+
+		for dx := -1; dx <= 1; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				neighbour := gridPoint{x: int(x) + dx*_cellSize, y: int(y) + dy*_cellSize}
+				key := fmt.Sprintf("%d,%d", neighbour.x, neighbour.y)
+				if _, requested := featureMap[key]; requested || seen[neighbour] {
+					continue
+				}
+
+				seen[neighbour] = true
+				points = append(points, neighbour)
+			}
+		}
+	}
+
+	return points
+}
+
+// collectCells returns the cells with data on the end date from the responses.
+func collectCells(
+	provider GridProvider,
+	startDate, endDate string,
+	responses ...models.NVEMultiPointTimeSeriesResponse,
+) []gridCell {
+	var cells []gridCell
+
+	for _, response := range responses {
+		isNoData := func(v float64) bool {
+			return response.IsNoData(v) || provider.IsNoData(v)
+		}
+
+		for _, cellTimeSeries := range response.CellTimeSeries {
+			data := cellTimeSeries.Data
+			if len(data) == 0 || isNoData(data[len(data)-1]) {
+				continue
+			}
+
+			cell := gridCell{
+				x:     float64(cellTimeSeries.X),
+				y:     float64(cellTimeSeries.Y),
+				value: data[len(data)-1],
+			}
+
+			if startDate != endDate {
+				series, err := createDailySeries(startDate, data, response, isNoData)
+				if err != nil {
+					log.Warn().Msg("Failed to create series for interpolation: " + err.Error())
+					continue
+				}
+				cell.series = series
+			}
+
+			cells = append(cells, cell)
+		}
+	}
+
+	return cells
+}
+
+// interpolateGaps fills the gaps with the inverse distance weighted value of the cells within
+// _maxInterpolationDistance of the cluster centre, and marks the values as interpolated.
+// Gaps without any cells nearby are left as they are.
+func interpolateGaps(
+	featureMap *map[string][]models.ForestRoad,
+	provider GridProvider,
+	gaps []string,
+	cells []gridCell,
+	prognose bool,
+) {
+	for _, key := range gaps {
+		x, y, err := parseClusterKey(key)
+		if err != nil {
+			log.Warn().Msg(err.Error())
+			continue
+		}
+
+		var neighbours []gridCell
+		var weights []float64
+		for _, cell := range cells {
+			distance := math.Hypot(cell.x-x, cell.y-y)
+			if distance > _maxInterpolationDistance {
+				continue
+			}
+
+			neighbours = append(neighbours, cell)
+			// Inverse distance weighting with power 2, a cell at the centre decides alone
+			weights = append(weights, 1/math.Max(distance*distance, 1e-9))
+		}
+
+		if len(neighbours) == 0 {
+			log.Debug().Msgf("no %s data near gap: %s", provider.Theme(), key)
+			continue
+		}
+
+		value := GridValue{
+			Status:   models.DataStatusInterpolated,
+			Prognose: prognose,
+		}
+
+		var sum, weightSum float64
+		for i, cell := range neighbours {
+			sum += weights[i] * cell.value
+			weightSum += weights[i]
+		}
+		interpolated := sum / weightSum
+		value.Value = &interpolated
+
+		if neighbours[0].series != nil {
+			value.Series = interpolateSeries(neighbours, weights)
+		}
+
+		roads := (*featureMap)[key]
+		for i := range roads {
+			provider.Set(&roads[i], value)
+		}
+	}
+}
+
+// interpolateSeries weights the series of the neighbours day by day, skipping days a neighbour has no data.
+func interpolateSeries(neighbours []gridCell, weights []float64) []models.DailyValue {
+	series := make([]models.DailyValue, len(neighbours[0].series))
+
+	for day := range series {
+		series[day].Dato = neighbours[0].series[day].Dato
+		series[day].Prognose = neighbours[0].series[day].Prognose
+
+		var sum, weightSum float64
+		for i, cell := range neighbours {
+			if day >= len(cell.series) || cell.series[day].Verdi == nil {
+				continue
+			}
+			sum += weights[i] * *cell.series[day].Verdi
+			weightSum += weights[i]
+		}
+
+		if weightSum > 0 {
+			value := sum / weightSum
+			series[day].Verdi = &value
+		}
+	}
+
+	return series
+}
+
+// parseClusterKey parses a cluster key formatted as "X,Y".
+func parseClusterKey(key string) (float64, float64, error) {
+	sliced := strings.Split(key, ",")
+	if len(sliced) != 2 {
+		return 0, 0, fmt.Errorf("invalid cluster key: %s", key)
+	}
+
+	x, err := strconv.ParseFloat(sliced[0], 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse float: %s", sliced[0])
+	}
+
+	y, err := strconv.ParseFloat(sliced[1], 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse float: %s", sliced[1])
+	}
+
+	return x, y, nil
+}
@@ -0,0 +1,179 @@
+package senorge
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"skogkursbachelor/server/internal/constants"
+	"skogkursbachelor/server/internal/models"
+	"slices"
+)
+
+// _themesFile is the file extra SeNorge themes are loaded from. See senorge.json
+const _themesFile = "senorge.json"
+
+// GridProvider is a SeNorge grid theme that is fetched for every forest road cluster,
+// and written to a property on the roads in the cluster.
+type GridProvider interface {
+	// Theme is the SeNorge theme name, e.g. gwb_frd
+	Theme() string
+	// Property is the name of the forest road property the values are written to
+	Property() string
+	// Unit is the unit of the values, e.g. cm
+	Unit() string
+	// IsNoData checks if a value marks a grid cell without data
+	IsNoData(value float64) bool
+	// Set writes the value for the cell the road is clustered in to the road
+	Set(road *models.ForestRoad, value GridValue)
+}
+
+// GridValue is the value of a theme for a grid cell.
+type GridValue struct {
+	// Value is the value on the end date of the request, nil when there is no data
+	Value *float64
+	// Status is the data status of the value, see models.DataStatusOK
+	Status string
+	// Prognose is true when Value is from the SeNorge forecast
+	Prognose bool
+	// Series holds the daily values, only set when a date range is requested
+	Series []models.DailyValue
+}
+
+// ThemeProvider is a GridProvider configured by theme name, property, unit and no-data values.
+// Themes loaded from senorge.json are written as extra properties on the roads, named after the property:
+// <property>, <property>erprognose and <property>serie.
+type ThemeProvider struct {
+	ThemeName    string    `json:"theme"`
+	PropertyName string    `json:"property"`
+	UnitName     string    `json:"unit"`
+	NoDataValues []float64 `json:"noDataValues"`
+
+	// set writes the value to typed fields on the road, if nil the value is written as extra properties
+	set func(road *models.ForestRoad, value GridValue)
+}
+
+func (provider *ThemeProvider) Theme() string {
+	return provider.ThemeName
+}
+
+func (provider *ThemeProvider) Property() string {
+	return provider.PropertyName
+}
+
+func (provider *ThemeProvider) Unit() string {
+	return provider.UnitName
+}
+
+func (provider *ThemeProvider) IsNoData(value float64) bool {
+	return slices.Contains(provider.NoDataValues, value)
+}
+
+func (provider *ThemeProvider) Set(road *models.ForestRoad, value GridValue) {
+	road.Properties.SetDataStatus(provider.PropertyName, value.Status)
+
+	if provider.set != nil {
+		provider.set(road, value)
+		return
+	}
+
+	road.Properties.SetEkstra(provider.PropertyName, value.Value)
+	road.Properties.SetEkstra(provider.PropertyName+"erprognose", value.Prognose)
+	if value.Series != nil {
+		road.Properties.SetEkstra(provider.PropertyName+"serie", value.Series)
+	} else {
+		delete(road.Properties.Ekstra, provider.PropertyName+"serie")
+	}
+}
+
+// _providers are the themes fetched for every request, the built-in themes are always first
+var _providers = []GridProvider{
+	&ThemeProvider{
+		ThemeName:    constants.SeNorgeFrostDepthTheme,
+		PropertyName: "teledybde",
+		UnitName:     "cm",
+		set: func(road *models.ForestRoad, value GridValue) {
+			road.Properties.Teledybde = value.Value
+			road.Properties.Teledybdeerprognose = value.Prognose
+			road.Properties.Teledybdeserie = value.Series
+		},
+	},
+	&ThemeProvider{
+		ThemeName:    constants.SeNorgeWaterSaturationTheme,
+		PropertyName: "vannmetning",
+		UnitName:     "%",
+		set: func(road *models.ForestRoad, value GridValue) {
+			road.Properties.Vannmetning = value.Value
+			road.Properties.Vannmetningerprognose = value.Prognose
+			road.Properties.Vannmetningserie = value.Series
+		},
+	},
+	&ThemeProvider{
+		ThemeName:    constants.SeNorgeSnowDepthTheme,
+		PropertyName: "snødybde",
+		UnitName:     "cm",
+		set: func(road *models.ForestRoad, value GridValue) {
+			road.Properties.Snødybde = value.Value
+			road.Properties.Snødybdeerprognose = value.Prognose
+			road.Properties.Snødybdeserie = value.Series
+		},
+	},
+	&ThemeProvider{
+		ThemeName:    constants.SeNorgeSnowWaterEquivalentTheme,
+		PropertyName: "snøvannekvivalent",
+		UnitName:     "mm",
+		set: func(road *models.ForestRoad, value GridValue) {
+			road.Properties.Snøvannekvivalent = value.Value
+			road.Properties.Snøvannekvivalenterprognose = value.Prognose
+			road.Properties.Snøvannekvivalentserie = value.Series
+		},
+	},
+}
+
+// LoadThemesFromFile loads extra SeNorge themes from a JSON file, and adds them after the built-in themes.
+func LoadThemesFromFile() error {
+	data, err := os.ReadFile(_themesFile)
+	if err != nil {
+		return err
+	}
+
+	var config struct {
+		Themes []*ThemeProvider `json:"themes"`
+	}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return err
+	}
+
+	for _, theme := range config.Themes {
+		if theme.ThemeName == "" || theme.PropertyName == "" {
+			return fmt.Errorf("theme and property are required in %s", _themesFile)
+		}
+
+		// The values are written to <property>, <property>erprognose and <property>serie, which must not be fixed
+		// properties of the roads
+		for _, key := range []string{theme.PropertyName, theme.PropertyName + "erprognose", theme.PropertyName + "serie"} {
+			if models.IsForestRoadProperty(key) {
+				return fmt.Errorf("property %s of %s is a forestry road property", key, theme.ThemeName)
+			}
+		}
+
+		for _, provider := range _providers {
+			if provider.Property() == theme.PropertyName {
+				return fmt.Errorf("property %s is used by both %s and %s", theme.PropertyName, provider.Theme(), theme.ThemeName)
+			}
+		}
+
+		_providers = append(_providers, theme)
+	}
+
+	return nil
+}
+
+// Units returns the unit of every theme, keyed by property.
+func Units() map[string]string {
+	units := make(map[string]string, len(_providers))
+	for _, provider := range _providers {
+		units[provider.Property()] = provider.Unit()
+	}
+	return units
+}
@@ -1,156 +1,241 @@
+// Package senorge fetches grid data from the SeNorge time series API at NVE.
 package senorge
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"skogkursbachelor/server/internal/constants"
+	"skogkursbachelor/server/internal/http/upstream"
 	"skogkursbachelor/server/internal/models"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
-func UpdateFrostDepth(featureMap *map[string][]models.ForestRoad, date string) error {
-	coordinatesString, err := createCoordinateString(*featureMap)
+// UpdateGridData fetches every SeNorge theme for the date range, and sets the values on the roads in the feature map.
+// The themes are fetched concurrently, a theme that fails does not stop the others. Its values are left as null,
+// with the status failed, and a warning is returned for it. Requests still running when the context is cancelled
+// are abandoned.
+func UpdateGridData(
+	ctx context.Context,
+	featureMap *map[string][]models.ForestRoad,
+	startDate, endDate string,
+) []models.Warning {
+	// Every property is null until a value is found for its cell
+	resetGridValues(featureMap, "")
+
+	points, err := createGridPoints(*featureMap)
 	if err != nil {
-		return fmt.Errorf("failed to create coordinate string: %v", err)
+		resetGridValues(featureMap, models.DataStatusFailed)
+		return []models.Warning{{Source: "senorge", Message: "failed to create coordinates: " + err.Error()}}
 	}
 
-	if coordinatesString == "" {
-		for _, value := range *featureMap {
-			for _, feature := range value {
-				feature.Properties.Teledybde = 0
-			}
-		}
+	// Every cluster centre is under water, there is nothing to request
+	if len(points) == 0 {
+		return nil
 	}
 
-	body := models.NVEFMultiPointTimeSeriesRequest{
-		Theme:            constants.SeNorgeFrostDepthTheme,
-		StartDate:        date + "T12",
-		EndDate:          date + "T12",
-		Format:           "json",
-		MapCoordinateCsv: coordinatesString,
-	}
+	responses := make([]models.NVEMultiPointTimeSeriesResponse, len(_providers))
+	errs := make([]error, len(_providers))
 
-	bodyJSON, err := json.Marshal(body)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request body: %v", err)
+	var wg sync.WaitGroup
+	for i, provider := range _providers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i], errs[i] = getTheme(ctx, provider.Theme(), startDate, endDate, points)
+		}()
 	}
+	wg.Wait()
 
-	// Use NVE api to get frost data
-	r, err := http.NewRequest(
-		http.MethodPost,
-		constants.NVEFrostDepthAPI,
-		bytes.NewBuffer(bodyJSON),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
-	}
+	var warnings []models.Warning
 
-	r.Header.Set("Content-Type", "application/json")
+	// Set the values after all requests are done, so only one goroutine writes to the roads
+	for i, provider := range _providers {
+		source := "senorge/" + provider.Theme()
 
-	// Do the request
-	resp, err := http.DefaultClient.Do(r)
-	if err != nil {
-		return fmt.Errorf("failed to do request: %v", err)
+		// When only some chunks failed, the rest of the values are used, and the failed cells are left as gaps
+		var chunkErr *ChunkError
+		if errors.As(errs[i], &chunkErr) && chunkErr.Partial() {
+			warnings = append(warnings, models.Warning{Source: source, Message: chunkErr.Error()})
+			errs[i] = nil
+		}
+
+		if errs[i] == nil {
+			errs[i] = setGridValues(featureMap, provider, responses[i], startDate, endDate)
+		}
+
+		if errs[i] != nil {
+			log.Error().Msgf("Error getting SeNorge %s data: %s", provider.Theme(), errs[i].Error())
+			warnings = append(warnings, models.Warning{Source: source, Message: errs[i].Error()})
+			setStatus(featureMap, provider, models.DataStatusFailed)
+		}
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to fetch frozen status: %s", resp.Status)
+	if ctx.Err() != nil {
+		return warnings
 	}
 
-	defer resp.Body.Close()
+	fillGaps(ctx, featureMap, responses, errs, startDate, endDate)
 
-	// Decode response
-	response := models.NVEMultiPointTimeSeriesResponse{}
-	err = json.NewDecoder(resp.Body).Decode(&response)
-	if err != nil {
-		return fmt.Errorf("failed to unmarshal response: %v", err)
-	}
+	return warnings
+}
 
-	if len(response.CellTimeSeries) == 0 {
-		return fmt.Errorf("no data in response")
-	}
+// fillGaps interpolates values for clusters without data from the neighbouring grid cells.
+// Neighbouring cells that were not part of the first request are fetched, failures are only logged.
+func fillGaps(
+	ctx context.Context,
+	featureMap *map[string][]models.ForestRoad,
+	responses []models.NVEMultiPointTimeSeriesResponse,
+	errs []error,
+	startDate, endDate string,
+) {
+	gaps := make([][]string, len(_providers))
+	neighbourResponses := make([]models.NVEMultiPointTimeSeriesResponse, len(_providers))
+
+	var wg sync.WaitGroup
+	for i, provider := range _providers {
+		if errs[i] != nil {
+			continue
+		}
 
-	for i := range response.CellTimeSeries {
-		key := fmt.Sprintf("%d,%d", response.CellTimeSeries[i].X, response.CellTimeSeries[i].Y)
-		slice, ok := (*featureMap)[key]
-		if !ok {
-			log.Warn().Msgf("featureMap does not contain key: %s", key)
+		gaps[i] = findGaps(*featureMap, provider)
+		if len(gaps[i]) == 0 {
+			continue
 		}
 
-		for j := range slice {
-			slice[j].Properties.Teledybde = response.CellTimeSeries[i].Data[0]
+		neighbours := createNeighbourGridPoints(*featureMap, gaps[i])
+		if len(neighbours) == 0 {
+			continue
 		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			neighbourResponses[i], err = getTheme(ctx, provider.Theme(), startDate, endDate, neighbours)
+			if err != nil {
+				log.Warn().Msgf("Failed to fetch %s neighbours for interpolation: %s", provider.Theme(), err.Error())
+			}
+		}()
 	}
+	wg.Wait()
 
-	return nil
-}
+	for i, provider := range _providers {
+		if len(gaps[i]) == 0 {
+			continue
+		}
 
-func UpdateWaterSaturation(featureMap *map[string][]models.ForestRoad, date string) error {
-	coordinatesString, err := createCoordinateString(*featureMap)
-	if err != nil {
-		return fmt.Errorf("failed to create coordinate string: %v", err)
+		cells := collectCells(provider, startDate, endDate, responses[i], neighbourResponses[i])
+		interpolateGaps(featureMap, provider, gaps[i], cells, responses[i].IsPrognose(endDate))
 	}
+}
 
-	if coordinatesString == "" {
-		for _, value := range *featureMap {
-			for _, feature := range value {
-				feature.Properties.Vannmetning = 0
+// resetGridValues sets every theme on every road to null. The status is status if given, otherwise underwater
+// for clusters in a fjord and missing for the rest, until the values are set from a response.
+func resetGridValues(featureMap *map[string][]models.ForestRoad, status string) {
+	for _, roads := range *featureMap {
+		clusterStatus := status
+		if clusterStatus == "" {
+			clusterStatus = models.DataStatusMissing
+			if len(roads) > 0 && roads[0].Properties.Erklyngesenterundervann {
+				clusterStatus = models.DataStatusUnderwater
+			}
+		}
+
+		for i := range roads {
+			for _, provider := range _providers {
+				provider.Set(&roads[i], GridValue{Status: clusterStatus})
 			}
 		}
 	}
+}
+
+// setStatus sets the theme to null with the status on every road that is not under water.
+func setStatus(featureMap *map[string][]models.ForestRoad, provider GridProvider, status string) {
+	for _, roads := range *featureMap {
+		if len(roads) == 0 || roads[0].Properties.Erklyngesenterundervann {
+			continue
+		}
+
+		for i := range roads {
+			provider.Set(&roads[i], GridValue{Status: status})
+		}
+	}
+}
+
+// fetchChunk requests the time series of a theme for the grid points from the NVE API in one request.
+func fetchChunk(
+	ctx context.Context,
+	theme, startDate, endDate string,
+	points []gridPoint,
+) (models.NVEMultiPointTimeSeriesResponse, error) {
+	response := models.NVEMultiPointTimeSeriesResponse{}
 
 	body := models.NVEFMultiPointTimeSeriesRequest{
-		Theme:            constants.SeNorgeWaterSaturationTheme,
-		StartDate:        date + "T12",
-		EndDate:          date + "T12",
+		Theme:            theme,
+		StartDate:        startDate + "T12",
+		EndDate:          endDate + "T12",
 		Format:           "json",
-		MapCoordinateCsv: coordinatesString,
+		MapCoordinateCsv: formatGridPoints(points),
 	}
 
 	bodyJSON, err := json.Marshal(body)
 	if err != nil {
-		return fmt.Errorf("failed to marshal request body: %v", err)
+		return response, fmt.Errorf("failed to marshal request body: %v", err)
 	}
 
-	// Use NVE api to get frost data
-	r, err := http.NewRequest(
+	// Use NVE api to get grid data
+	r, err := http.NewRequestWithContext(
+		ctx,
 		http.MethodPost,
 		constants.NVEFrostDepthAPI,
 		bytes.NewBuffer(bodyJSON),
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
+		return response, fmt.Errorf("failed to create request: %v", err)
 	}
 
 	r.Header.Set("Content-Type", "application/json")
 
 	// Do the request
-	resp, err := http.DefaultClient.Do(r)
+	resp, err := upstream.Do(r)
 	if err != nil {
-		return fmt.Errorf("failed to do request: %v", err)
+		return response, fmt.Errorf("failed to do request: %v", err)
 	}
 
+	defer resp.Body.Close()
+
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to fetch frozen status: %s", resp.Status)
+		return response, fmt.Errorf("failed to fetch grid data: %s", resp.Status)
 	}
 
-	defer resp.Body.Close()
-
 	// Decode response
-	response := models.NVEMultiPointTimeSeriesResponse{}
 	err = json.NewDecoder(resp.Body).Decode(&response)
 	if err != nil {
-		return fmt.Errorf("failed to decode response: %v", err)
+		return response, fmt.Errorf("failed to decode response: %v", err)
 	}
 
 	if len(response.CellTimeSeries) == 0 {
-		return fmt.Errorf("no data in response")
+		return response, fmt.Errorf("no data in response")
 	}
 
+	return response, nil
+}
+
+// setGridValues sets the value of every cell in the response on the roads clustered in that cell.
+func setGridValues(
+	featureMap *map[string][]models.ForestRoad,
+	provider GridProvider,
+	response models.NVEMultiPointTimeSeriesResponse,
+	startDate, endDate string,
+) error {
 	for i := range response.CellTimeSeries {
 		key := fmt.Sprintf("%d,%d", response.CellTimeSeries[i].X, response.CellTimeSeries[i].Y)
 		slice, ok := (*featureMap)[key]
@@ -158,39 +243,108 @@ func UpdateWaterSaturation(featureMap *map[string][]models.ForestRoad, date stri
 			log.Warn().Msgf("featureMap does not contain key: %s", key)
 		}
 
+		data := response.CellTimeSeries[i].Data
+		if len(data) == 0 {
+			log.Warn().Msgf("no %s data for key: %s", provider.Theme(), key)
+			continue
+		}
+
+		isNoData := func(v float64) bool {
+			return response.IsNoData(v) || provider.IsNoData(v)
+		}
+
+		value := GridValue{
+			Status:   models.DataStatusNoData,
+			Prognose: response.IsPrognose(endDate),
+		}
+		if last := data[len(data)-1]; !isNoData(last) {
+			value.Value = &last
+			value.Status = models.DataStatusOK
+		}
+
+		if startDate != endDate {
+			series, err := createDailySeries(startDate, data, response, isNoData)
+			if err != nil {
+				return err
+			}
+			value.Series = series
+		}
+
 		for j := range slice {
-			slice[j].Properties.Vannmetning = response.CellTimeSeries[i].Data[0]
+			provider.Set(&slice[j], value)
 		}
 	}
 
 	return nil
 }
 
-func createCoordinateString(featureMap map[string][]models.ForestRoad) (string, error) {
-	if len(featureMap) == 0 {
-		return "", fmt.Errorf("feature map is empty")
+// createDailySeries pairs daily values with their dates, counting from startDate,
+// and marks the values from the forecast part of the response. Values without data are nil.
+func createDailySeries(
+	startDate string,
+	data []float64,
+	response models.NVEMultiPointTimeSeriesResponse,
+	isNoData func(float64) bool,
+) ([]models.DailyValue, error) {
+	start, err := time.Parse(time.DateOnly, startDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse start date: %v", err)
 	}
 
-	// Coordinates is in format "X1 Y1, X2 Y2, ..."
-	stringBuilder := strings.Builder{}
+	series := make([]models.DailyValue, len(data))
+	for i, value := range data {
+		date := start.AddDate(0, 0, i).Format(time.DateOnly)
+		series[i] = models.DailyValue{
+			Dato:     date,
+			Prognose: response.IsPrognose(date),
+		}
+		if !isNoData(value) {
+			series[i].Verdi = &value
+		}
+	}
+
+	return series, nil
+}
+
+// gridPoint is the centre of a SeNorge grid cell, in EPSG:25833 meters
+type gridPoint struct {
+	x, y int
+}
+
+// createGridPoints returns the cluster centres of the feature map, except the ones under water.
+func createGridPoints(featureMap map[string][]models.ForestRoad) ([]gridPoint, error) {
+	if len(featureMap) == 0 {
+		return nil, fmt.Errorf("feature map is empty")
+	}
 
+	points := make([]gridPoint, 0, len(featureMap))
 	for key, array := range featureMap {
-		// If feature is on superficial code 1 (Løsmasser/berggrunn under vann,uspesifisert), skip
+		// If the cluster centre is in a fjord, SeNorge has no data for it, skip
 		if array[0].Properties.Erklyngesenterundervann {
 			continue
 		}
 
-		stringBuilder.WriteString(strings.ReplaceAll(key, ",", " "))
-		stringBuilder.WriteString(", ")
+		x, y, err := parseClusterKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		points = append(points, gridPoint{x: int(x), y: int(y)})
 	}
 
-	coordinatesString := stringBuilder.String()
+	return points, nil
+}
+
+// formatGridPoints formats the points as the NVE API expects, "X1 Y1, X2 Y2, ..."
+func formatGridPoints(points []gridPoint) string {
+	stringBuilder := strings.Builder{}
 
-	// Remove last comma
-	length := len(coordinatesString)
-	if length > 0 {
-		coordinatesString = coordinatesString[:length-1]
+	for i, point := range points {
+		if i > 0 {
+			stringBuilder.WriteString(", ")
+		}
+		stringBuilder.WriteString(fmt.Sprintf("%d %d", point.x, point.y))
 	}
 
-	return coordinatesString, nil
+	return stringBuilder.String()
 }
@@ -0,0 +1,158 @@
+package senorge
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// _store keeps final SeNorge values on disk across restarts, it is nil until InitStore is called
+var _store *diskStore
+
+// _storeFile is the name of the database file in the data directory
+const _storeFile = "senorge.db"
+
+// diskStore is an embedded bbolt database with one bucket per theme.
+// Keys are "YYYY-MM-DD/X,Y", values are the float64 bits followed by a no-data flag.
+type diskStore struct {
+	db *bolt.DB
+}
+
+// InitStore opens, or creates, the SeNorge store in the data directory.
+// An empty data directory disables the store.
+func InitStore(dataDir string) error {
+	if dataDir == "" {
+		log.Info().Msg("SeNorge disk store disabled")
+		return nil
+	}
+
+	err := os.MkdirAll(dataDir, 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	path := filepath.Join(dataDir, _storeFile)
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", path, err)
+	}
+
+	_store = &diskStore{db: db}
+	log.Info().Msgf("SeNorge disk store opened at %s", path)
+	return nil
+}
+
+// CheckStore checks that the SeNorge store in the data directory can be opened, without creating it or locking it
+// for writing. A store that does not exist yet is created by InitStore, and a store locked by a running server is
+// in use, so both pass the check.
+func CheckStore(dataDir string) error {
+	if dataDir == "" {
+		return nil
+	}
+
+	path := filepath.Join(dataDir, _storeFile)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second, ReadOnly: true})
+	if errors.Is(err, bolt.ErrTimeout) {
+		log.Info().Msgf("SeNorge store %s is locked, it is in use by a running server", path)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	return db.Close()
+}
+
+// lookup returns the stored values of the points that are stored for every date, and the points that are not.
+func (store *diskStore) lookup(theme string, dates []string, points []gridPoint) (map[gridPoint][]cacheEntry, []gridPoint) {
+	stored := make(map[gridPoint][]cacheEntry)
+	var missing []gridPoint
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(theme))
+
+		for _, point := range points {
+			if bucket == nil {
+				missing = append(missing, point)
+				continue
+			}
+
+			entries := make([]cacheEntry, 0, len(dates))
+			for _, date := range dates {
+				key := cacheKey{theme: theme, date: date, point: point}
+				data := bucket.Get(storeKey(key))
+				if len(data) != 9 {
+					break
+				}
+
+				entries = append(entries, cacheEntry{
+					key:    key,
+					value:  math.Float64frombits(binary.BigEndian.Uint64(data[:8])),
+					noData: data[8] == 1,
+					final:  true,
+				})
+			}
+
+			if len(entries) == len(dates) {
+				stored[point] = entries
+			} else {
+				missing = append(missing, point)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Warn().Msg("Failed to read SeNorge disk store: " + err.Error())
+		return nil, points
+	}
+
+	return stored, missing
+}
+
+// put writes the final entries to the store, other entries are skipped since they may still change.
+func (store *diskStore) put(entries []cacheEntry) {
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		for _, entry := range entries {
+			if !entry.final {
+				continue
+			}
+
+			bucket, err := tx.CreateBucketIfNotExists([]byte(entry.key.theme))
+			if err != nil {
+				return err
+			}
+
+			data := make([]byte, 9)
+			binary.BigEndian.PutUint64(data[:8], math.Float64bits(entry.value))
+			if entry.noData {
+				data[8] = 1
+			}
+
+			err = bucket.Put(storeKey(entry.key), data)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Warn().Msg("Failed to write SeNorge disk store: " + err.Error())
+	}
+}
+
+// storeKey formats the key of an entry within its theme bucket, dates first so that keys sort by date.
+func storeKey(key cacheKey) []byte {
+	return []byte(fmt.Sprintf("%s/%d,%d", key.date, key.point.x, key.point.y))
+}
@@ -0,0 +1,280 @@
+package superficialdeposits
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"skogkursbachelor/server/internal/models"
+	"skogkursbachelor/server/internal/utils"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// _store holds precomputed deposits by road, it is nil until InitStore is called
+var _store *bolt.DB
+
+// _storeFile is the name of the database file in the data directory
+const _storeFile = "deposits.db"
+
+// _depositsBucket maps "kommunenummer/vegnummer/strekningnummer/delstrekningnummer/frameter/tilmeter" to the
+// storedDeposits of every line with those numbers, encoded as JSON. The lines of a MultiLineString share numbers.
+var _depositsBucket = []byte("deposits")
+
+// _endpointTolerance is how far, in meters, the ends of a road may move before its stored deposits are not used
+const _endpointTolerance = 1.0
+
+// storedDeposits are the precomputed deposits of a road. The ends of the road are kept, so deposits are not
+// used for a road that has been changed since, and are found for the line among the lines with the same numbers.
+type storedDeposits struct {
+	First    []float64               `json:"first"`
+	Last     []float64               `json:"last"`
+	Codes    []int                   `json:"codes"`
+	Segments []models.DepositSegment `json:"segments"`
+	Computed time.Time               `json:"computed"`
+}
+
+// InitStore opens the precomputed deposits in the data directory for reading, if they exist.
+// An empty data directory, or a directory without precomputed deposits, disables the store.
+func InitStore(dataDir string) error {
+	if dataDir == "" {
+		log.Info().Msg("Precomputed superficial deposits disabled")
+		return nil
+	}
+
+	path := filepath.Join(dataDir, _storeFile)
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		log.Info().Msgf("No precomputed superficial deposits at %s, deposits are looked up per request", path)
+		return nil
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", path, err)
+	}
+
+	_store = db
+	log.Info().Msgf("Precomputed superficial deposits opened at %s", path)
+	return nil
+}
+
+// Precompute looks up the superficial deposits of the roads, and stores them in the data directory, so they are
+// read from the store instead of looked up for every request. Roads without a vegnummer can not be stored,
+// and are skipped. Returns the number of roads stored.
+func Precompute(ctx context.Context, roads []models.ForestRoad, dataDir string) (int, error) {
+	if _store != nil {
+		return 0, fmt.Errorf("the deposit store is open for reading, stop the server before precomputing")
+	}
+
+	err := os.MkdirAll(dataDir, 0o755)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	path := filepath.Join(dataDir, _storeFile)
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(_depositsBucket)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	semaphore := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var done, failed atomic.Int64
+	batch := make(map[string][]storedDeposits)
+	batchSize := 0
+	stored := 0
+
+	flush := func() error {
+		err := db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(_depositsBucket)
+			for key, deposits := range batch {
+				// Lines with the same numbers may be in earlier batches
+				var existing []storedDeposits
+				if data := bucket.Get([]byte(key)); data != nil {
+					err := json.Unmarshal(data, &existing)
+					if err != nil {
+						return err
+					}
+				}
+
+				data, err := json.Marshal(mergeDeposits(existing, deposits))
+				if err != nil {
+					return err
+				}
+				err = bucket.Put([]byte(key), data)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err == nil {
+			stored += batchSize
+		}
+		clear(batch)
+		batchSize = 0
+		return err
+	}
+
+	for i := range roads {
+		key := storeKey(roads[i])
+		if key == "" || len(roads[i].Geometry.Coordinates) == 0 {
+			continue
+		}
+
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			// Keep the deposits looked up before the job was interrupted
+			wg.Wait()
+			err = flush()
+			if err != nil {
+				return stored, fmt.Errorf("failed to write deposits: %v", err)
+			}
+			return stored, ctx.Err()
+		}
+
+		wg.Add(1)
+		go func(road models.ForestRoad) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			codes, segments, err := getSuperficialDepositsForRoad(ctx, road)
+			if err != nil {
+				// A lookup abandoned by an interrupted job has not failed
+				if ctx.Err() == nil {
+					log.Warn().Msg("Failed to get superficial deposit codes: " + err.Error())
+					failed.Add(1)
+				}
+				return
+			}
+
+			coordinates := road.Geometry.Coordinates
+			mu.Lock()
+			batch[key] = append(batch[key], storedDeposits{
+				First:    coordinates[0],
+				Last:     coordinates[len(coordinates)-1],
+				Codes:    codes,
+				Segments: segments,
+				Computed: time.Now(),
+			})
+			batchSize++
+			mu.Unlock()
+
+			if n := done.Add(1); n%10000 == 0 {
+				log.Info().Msgf("Looked up superficial deposits for %d of %d roads", n, len(roads))
+			}
+		}(roads[i])
+
+		// Write in batches, so an interrupted job keeps what it has done
+		mu.Lock()
+		if batchSize >= 5000 {
+			err = flush()
+		}
+		mu.Unlock()
+		if err != nil {
+			wg.Wait()
+			return stored, fmt.Errorf("failed to write deposits: %v", err)
+		}
+	}
+	wg.Wait()
+
+	err = flush()
+	if err != nil {
+		return stored, fmt.Errorf("failed to write deposits: %v", err)
+	}
+
+	if failed.Load() > 0 {
+		log.Warn().Msgf("Failed to get superficial deposits for %d roads", failed.Load())
+	}
+
+	return stored, nil
+}
+
+// lookupStored returns the precomputed deposits of the road, if they are stored and the road has not changed.
+func lookupStored(road models.ForestRoad) ([]int, []models.DepositSegment, bool) {
+	key := storeKey(road)
+	coordinates := road.Geometry.Coordinates
+	if _store == nil || key == "" || len(coordinates) == 0 {
+		return nil, nil, false
+	}
+
+	var deposits []storedDeposits
+	err := _store.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(_depositsBucket)
+		if bucket == nil {
+			return nil
+		}
+
+		data := bucket.Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+
+		return json.Unmarshal(data, &deposits)
+	})
+	if err != nil {
+		log.Warn().Msgf("Failed to read precomputed deposits for %s: %s", key, err.Error())
+		return nil, nil, false
+	}
+
+	for _, line := range deposits {
+		if line.hasEnds(coordinates[0], coordinates[len(coordinates)-1]) {
+			return line.Codes, line.Segments, true
+		}
+	}
+	return nil, nil, false
+}
+
+// hasEnds checks if the stored line ends within _endpointTolerance of the ends.
+func (deposits storedDeposits) hasEnds(first, last []float64) bool {
+	return utils.Distance(deposits.First, first) <= _endpointTolerance &&
+		utils.Distance(deposits.Last, last) <= _endpointTolerance
+}
+
+// mergeDeposits replaces the stored lines that have the same ends as an added line, and adds the other lines.
+func mergeDeposits(existing, added []storedDeposits) []storedDeposits {
+	for _, deposits := range added {
+		i := slices.IndexFunc(existing, func(line storedDeposits) bool {
+			return line.hasEnds(deposits.First, deposits.Last)
+		})
+		if i >= 0 {
+			existing[i] = deposits
+		} else {
+			existing = append(existing, deposits)
+		}
+	}
+	return existing
+}
+
+// storeKey returns the key of the road in the store, or an empty string for roads without a vegnummer.
+// Road numbers repeat across municipalities, and a delstrekning is split into several roads by meter.
+func storeKey(road models.ForestRoad) string {
+	properties := road.Properties
+	if properties.Vegnummer == "" {
+		return ""
+	}
+	return strings.Join([]string{
+		properties.Kommunenummer, properties.Vegnummer, properties.Strekningnummer, properties.Delstrekningnummer,
+		properties.Frameter, properties.Tilmeter,
+	}, "/")
+}
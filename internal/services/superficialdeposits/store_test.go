@@ -0,0 +1,97 @@
+package superficialdeposits
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"skogkursbachelor/server/internal/models"
+	"slices"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestStoreKey(t *testing.T) {
+	road := func(kommunenummer, frameter string) models.ForestRoad {
+		return models.ForestRoad{Properties: models.ForestRoadProperties{
+			Kommunenummer: kommunenummer, Vegnummer: "1234", Strekningnummer: "1", Delstrekningnummer: "2",
+			Frameter: frameter, Tilmeter: "500",
+		}}
+	}
+
+	if key := storeKey(road("3401", "0")); key != "3401/1234/1/2/0/500" {
+		t.Errorf("storeKey() = %q, want 3401/1234/1/2/0/500", key)
+	}
+	if storeKey(road("3401", "0")) == storeKey(road("5001", "0")) {
+		t.Errorf("roads in different municipalities have the same key")
+	}
+	if storeKey(road("3401", "0")) == storeKey(road("3401", "100")) {
+		t.Errorf("roads with different meters have the same key")
+	}
+	if key := storeKey(models.ForestRoad{}); key != "" {
+		t.Errorf("storeKey() without vegnummer = %q, want empty", key)
+	}
+}
+
+func TestLookupStored(t *testing.T) {
+	// Two lines of a MultiLineString, with the same numbers
+	first := storedDeposits{First: []float64{0, 0}, Last: []float64{100, 0}, Codes: []int{11}}
+	second := storedDeposits{First: []float64{100, 50}, Last: []float64{200, 50}, Codes: []int{90}}
+	updated := storedDeposits{First: []float64{0, 0.5}, Last: []float64{100, 0}, Codes: []int{12}}
+
+	lines := mergeDeposits(nil, []storedDeposits{first})
+	lines = mergeDeposits(lines, []storedDeposits{second, updated})
+	if len(lines) != 2 {
+		t.Fatalf("mergeDeposits kept %d lines, want 2", len(lines))
+	}
+
+	db, err := bolt.Open(filepath.Join(t.TempDir(), _storeFile), 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	road := func(kommunenummer string, coordinates ...[]float64) models.ForestRoad {
+		road := models.ForestRoad{Properties: models.ForestRoadProperties{Kommunenummer: kommunenummer, Vegnummer: "1234"}}
+		road.Geometry.Coordinates = coordinates
+		return road
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket(_depositsBucket)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(lines)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(storeKey(road("3401"))), data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(previous *bolt.DB) { _store = previous }(_store)
+	_store = db
+
+	tests := []struct {
+		name      string
+		road      models.ForestRoad
+		wantCodes []int
+		wantFound bool
+	}{
+		{name: "first line", road: road("3401", []float64{0, 0}, []float64{50, 0}, []float64{100, 0}), wantCodes: []int{12}, wantFound: true},
+		{name: "second line", road: road("3401", []float64{100.5, 50}, []float64{200, 50}), wantCodes: []int{90}, wantFound: true},
+		{name: "moved end", road: road("3401", []float64{0, 0}, []float64{110, 0})},
+		{name: "other municipality", road: road("5001", []float64{0, 0}, []float64{100, 0})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codes, _, found := lookupStored(tt.road)
+			if found != tt.wantFound || !slices.Equal(codes, tt.wantCodes) {
+				t.Errorf("lookupStored() = %v, %v, want %v, %v", codes, found, tt.wantCodes, tt.wantFound)
+			}
+		})
+	}
+}
@@ -1,43 +1,162 @@
 package superficialdeposits
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"os"
 	"runtime"
 	"skogkursbachelor/server/internal/models"
+	"skogkursbachelor/server/internal/utils"
 	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/rs/zerolog/log"
 )
 
 // index is a spatial index for the forestry roads
-var _index = buildIndex()
+var _index *models.SpatialIndex
 
-// var _fjordIndex = buildFjordIndex()
+// _fjordIndex is a spatial index for the fjord catalogue, used to find cluster centres under water
+var _fjordIndex *models.SpatialIndex
+
+// _codeNames maps superficial deposit codes to their names, see superficialdeposits_codes.json
+var _codeNames map[int]string
+
+// _loadOnce loads the indexes and code names on first use, see LoadIndexes
+var _loadOnce sync.Once
+
+// _depositShapefiles are the superficial deposit shapefiles, without extension
+var _depositShapefiles = []string{
+	"data/Losmasse/LosmasseFlate_20240621",
+	//"data/Losmasse/LosmasseFlate_20240622",
+}
+
+// _fjordShapefiles are the fjord catalogue shapefiles, without extension. They are optional.
+var _fjordShapefiles = []string{
+	"data/Fjord/fjordkatalogen_omrade",
+}
+
+// _codeNamesFile lists the name of every superficial deposit code
+const _codeNamesFile = "data/Losmasse/superficialdeposits_codes.json"
+
+// _sampleEveryMeter is the distance between the points along a road that are looked up in the index
+const _sampleEveryMeter = 10.0
+
+// LoadIndexes reads the shapefiles into spatial indexes, and reads the code names. Only the first call loads them,
+// lookups call it themselves. The server calls it at start, so the first request is not slowed by it.
+func LoadIndexes() {
+	_loadOnce.Do(func() {
+		_index = buildIndex()
+		_fjordIndex = buildFjordIndex()
+		_codeNames = loadCodeNames()
+	})
+}
+
+// IndexSizes loads the indexes, and returns the number of deposit and fjord polygons in them.
+func IndexSizes() (int, int) {
+	LoadIndexes()
+	return _index.Len(), _fjordIndex.Len()
+}
+
+// CheckFiles checks that the shapefiles and code names are in place, without reading them.
+// The fjord catalogue is optional, and is not checked.
+func CheckFiles() error {
+	var errs []error
+	for _, shapefile := range _depositShapefiles {
+		for _, extension := range []string{".shp", ".shx", ".dbf"} {
+			_, err := os.Stat(shapefile + extension)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	_, err := os.Stat(_codeNamesFile)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
 
 func buildIndex() *models.SpatialIndex {
-	shapefiles := []string{
-		"data/Losmasse/LosmasseFlate_20240621",
-		//"data/Losmasse/LosmasseFlate_20240622",
+	return models.ReadShapeFilesAndBuildIndex(_depositShapefiles)
+}
+
+func loadCodeNames() map[int]string {
+	names := make(map[int]string)
+
+	data, err := os.ReadFile(_codeNamesFile)
+	if err != nil {
+		log.Error().Msg("Error reading superficial deposit codes: " + err.Error())
+		return names
+	}
+
+	var codes []struct {
+		Code int    `json:"code"`
+		Name string `json:"name"`
+	}
+	err = json.Unmarshal(data, &codes)
+	if err != nil {
+		log.Error().Msg("Error decoding superficial deposit codes: " + err.Error())
+		return names
 	}
 
-	return models.ReadShapeFilesAndBuildIndex(shapefiles)
+	for _, code := range codes {
+		names[code.Code] = strings.TrimSpace(code.Name)
+	}
+
+	return names
+}
+
+func buildFjordIndex() *models.SpatialIndex {
+	return models.ReadShapeFilesAndBuildIndex(_fjordShapefiles)
 }
 
-// func buildFjordIndex() *models.SpatialIndex {
-// 	shapefiles := []string{
-// 		"data/Fjord/fjordkatalogen_omrade",
-// 	}
-//
-// 	return models.ReadShapeFilesAndBuildIndex(shapefiles)
-// }
+// PointDeposits are the superficial deposits at a point.
+type PointDeposits struct {
+	Løsmassekoder []int    `json:"løsmassekoder"`
+	Løsmassenavn  []string `json:"løsmassenavn"`
+	Erundervann   bool     `json:"erundervann"`
+}
 
-func UpdateSuperficialDepositCodes(featureMap *map[string][]models.ForestRoad) error {
+// QueryPoint returns the superficial deposit codes at the point, in EPSG:25833, and if it is in a fjord.
+func QueryPoint(x, y float64) (PointDeposits, error) {
+	codes, err := getSuperficialDepositCodesForPoint([]float64{x, y})
+	if err != nil {
+		return PointDeposits{}, err
+	}
+
+	inFjord, err := getIsPointInFjord([]float64{x, y})
+	if err != nil {
+		return PointDeposits{}, err
+	}
+
+	deposits := PointDeposits{Løsmassekoder: codes, Løsmassenavn: make([]string, len(codes)), Erundervann: inFjord}
+	for i, code := range codes {
+		deposits.Løsmassenavn[i] = _codeNames[code]
+	}
+
+	return deposits, nil
+}
+
+// UpdateSuperficialDepositCodes sets the superficial deposit codes and segments on every road, and flags the roads
+// in clusters with the centre in a fjord. Roads that fail keep going, and are counted in the returned error.
+// Roads that already have segments, ex: from the local road store, keep them.
+// When the context is cancelled, no more roads are started, and the context error is returned.
+func UpdateSuperficialDepositCodes(ctx context.Context, featureMap *map[string][]models.ForestRoad) error {
 	semaphore := make(chan struct{}, runtime.NumCPU())
 	var wg sync.WaitGroup
+	var failed atomic.Int64
+	total := 0
 
+roads:
 	for key, values := range *featureMap {
 		// Get code for key, used for validation for senorge
 		sliced := strings.Split(key, ",")
@@ -59,91 +178,173 @@ func UpdateSuperficialDepositCodes(featureMap *map[string][]models.ForestRoad) e
 		}
 
 		for i := range values {
-			wg.Add(1)
+			values[i].Properties.Erklyngesenterundervann = isInFjord
+			if values[i].Properties.Løsmassesegmenter != nil {
+				continue
+			}
+			total++
 
-			// Reserve a slot
-			semaphore <- struct{}{}
+			// Reserve a slot, or stop if the request is abandoned
+			select {
+			case semaphore <- struct{}{}:
+			case <-ctx.Done():
+				break roads
+			}
 
+			wg.Add(1)
 			go func(road *models.ForestRoad) {
 				defer wg.Done()
 				defer func() { <-semaphore }()
 
-				codes, err := getSuperficialDepositCodesForRoad(*road)
-				if err != nil {
+				err := UpdateRoadSuperficialDeposits(ctx, road)
+				if err != nil && ctx.Err() == nil {
 					log.Warn().Msg("Failed to get superficial deposit codes: " + err.Error())
-					return
+					failed.Add(1)
 				}
-
-				if len(codes) == 0 {
-					log.Warn().Msg("No superficial deposit codes found for road: " + road.Properties.Vegnummer)
-				}
-
-				road.Properties.Løsmassekoder = codes
-				road.Properties.Erklyngesenterundervann = isInFjord
 			}(&values[i])
 		}
 	}
 
 	wg.Wait()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if failed.Load() > 0 {
+		return fmt.Errorf("failed to get superficial deposit codes for %d of %d roads", failed.Load(), total)
+	}
+
 	return nil
 }
 
-func getSuperficialDepositCodesForRoad(road models.ForestRoad) ([]int, error) {
-	// Get the road length
-	roadStart, err := strconv.Atoi(road.Properties.Frameter)
-	if err != nil {
-		return nil, fmt.Errorf("failed to convert frameter to int: %s", err.Error())
+// UpdateRoadSuperficialDeposits sets the superficial deposit codes and segments on a single road.
+// Precomputed deposits are used if they are stored for the road, see Precompute.
+func UpdateRoadSuperficialDeposits(ctx context.Context, road *models.ForestRoad) error {
+	if codes, segments, ok := lookupStored(*road); ok {
+		road.Properties.Løsmassekoder = codes
+		road.Properties.Løsmassesegmenter = segments
+		return nil
 	}
-	roadEnd, err := strconv.Atoi(road.Properties.Tilmeter)
+
+	codes, segments, err := getSuperficialDepositsForRoad(ctx, *road)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert tilmeter to int: %s", err.Error())
+		return err
 	}
-	roadLength := roadEnd - roadStart
 
-	if roadLength < 0 {
-		return nil, fmt.Errorf("road length is negative %s", road.Properties.Vegnummer)
+	if len(codes) == 0 {
+		log.Warn().Msg("No superficial deposit codes found for road: " + road.Properties.Vegnummer)
 	}
 
-	queryEveryMeter := 10
-	queryAmount := roadLength / queryEveryMeter
-	if queryAmount == 0 {
-		queryAmount = 1
-	}
-	queryEveryIndex := len(road.Geometry.Coordinates) / queryAmount
+	road.Properties.Løsmassekoder = codes
+	road.Properties.Løsmassesegmenter = segments
+	return nil
+}
 
-	if queryEveryIndex == 0 {
-		queryEveryIndex = 1
+// getSuperficialDepositsForRoad samples the road along its metric length, and returns the distinct deposit codes
+// and an ordered list of segments with a single deposit code each. Stops early if the context is cancelled.
+func getSuperficialDepositsForRoad(ctx context.Context, road models.ForestRoad) ([]int, []models.DepositSegment, error) {
+	if len(road.Geometry.Coordinates) == 0 {
+		return nil, nil, fmt.Errorf("road has no coordinates %s", road.Properties.Vegnummer)
 	}
 
-	if queryAmount > len(road.Geometry.Coordinates) {
-		queryAmount = len(road.Geometry.Coordinates)
-	}
+	samples := sampleLine(road.Geometry.Coordinates, _sampleEveryMeter)
 
-	// Query every x meters
 	var codes []int
-	for i := 0; i < queryAmount; i += queryEveryIndex {
+	var segments []models.DepositSegment
+	for i, sample := range samples {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+
 		// Get the superficial deposit code for the current point
-		coordinates := road.Geometry.Coordinates[i]
-		codesForPoint, err := getSuperficialDepositCodesForPoint(coordinates)
+		codesForPoint, err := getSuperficialDepositCodesForPoint(sample.coordinate)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+
+		// Code 0 marks a point without any known deposit
+		pointCode := 0
 		for _, code := range codesForPoint {
 			// If code is 1 (Løsmasser/berggrunn under vann,uspesifisert), skip
 			if code == 1 {
 				continue
 			}
 
+			if pointCode == 0 {
+				pointCode = code
+			}
+
 			if !slices.Contains(codes, code) {
 				codes = append(codes, code)
 			}
 		}
+
+		if len(segments) > 0 && segments[len(segments)-1].Kode == pointCode {
+			continue
+		}
+
+		// Segments change halfway between two samples with different codes
+		from := 0.0
+		if i > 0 {
+			from = roundToDecimeter((samples[i-1].distance + sample.distance) / 2)
+			segments[len(segments)-1].TilMeter = from
+		}
+
+		segments = append(segments, models.DepositSegment{
+			FraMeter: from,
+			Kode:     pointCode,
+			Navn:     _codeNames[pointCode],
+		})
 	}
 
-	return codes, nil
+	segments[len(segments)-1].TilMeter = roundToDecimeter(samples[len(samples)-1].distance)
+
+	return codes, segments, nil
+}
+
+// lineSample is a point along a line, with its distance in meters from the start of the line.
+type lineSample struct {
+	coordinate []float64
+	distance   float64
+}
+
+// sampleLine returns points along the line every interval meters, always including the first and last coordinate.
+func sampleLine(coordinates [][]float64, interval float64) []lineSample {
+	samples := []lineSample{{coordinate: coordinates[0], distance: 0}}
+
+	travelled := 0.0
+	next := interval
+	for i := 1; i < len(coordinates); i++ {
+		start, end := coordinates[i-1], coordinates[i]
+		length := utils.Distance(start, end)
+
+		for next <= travelled+length && length > 0 {
+			t := (next - travelled) / length
+			samples = append(samples, lineSample{
+				coordinate: []float64{start[0] + t*(end[0]-start[0]), start[1] + t*(end[1]-start[1])},
+				distance:   next,
+			})
+			next += interval
+		}
+
+		travelled += length
+	}
+
+	if last := samples[len(samples)-1]; last.distance < travelled {
+		samples = append(samples, lineSample{coordinate: coordinates[len(coordinates)-1], distance: travelled})
+	}
+
+	return samples
+}
+
+func roundToDecimeter(meters float64) float64 {
+	return math.Round(meters*10) / 10
 }
 
 func getSuperficialDepositCodesForPoint(coordinate []float64) ([]int, error) {
+	LoadIndexes()
+
 	results, err := models.QuerySpatialIndex(_index, coordinate[0], coordinate[1])
 	if err != nil {
 		return nil, err
@@ -171,18 +372,15 @@ func getSuperficialDepositCodesForPoint(coordinate []float64) ([]int, error) {
 	return codes, nil
 }
 
+// getIsPointInFjord checks if the point is inside any polygon in the fjord catalogue
 func getIsPointInFjord(coordinate []float64) (bool, error) {
-	// results, err := models.QuerySpatialIndex(_fjordIndex, coordinate[0], coordinate[1])
-	// if err != nil {
-	// 	return false, fmt.Errorf("failed to query spatial index: %s", err.Error())
-	// }
-
-	// if results == nil {
-	// NOT CHECKING FOR FJORD TODO: CHECK FOR FJORD
-	if true {
-		// If result is nil -> the point is not in a fjord
-		return false, nil
-	} else {
-		return true, nil
+	LoadIndexes()
+
+	results, err := models.QuerySpatialIndex(_fjordIndex, coordinate[0], coordinate[1])
+	if err != nil {
+		return false, fmt.Errorf("failed to query spatial index: %s", err.Error())
 	}
+
+	// If there are no results, the point is not in a fjord
+	return len(results) > 0, nil
 }
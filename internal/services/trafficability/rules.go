@@ -0,0 +1,131 @@
+package trafficability
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// _rulesFile is the file the trafficability rules are loaded from. See trafficability.json
+const _rulesFile = "trafficability.json"
+
+// Rules holds the thresholds used to classify the trafficability of a forest road.
+type Rules struct {
+	// GreenMinScore is the lowest score that is still classified as green.
+	GreenMinScore float64 `json:"greenMinScore"`
+	// YellowMinScore is the lowest score that is still classified as yellow, anything below is red.
+	YellowMinScore float64 `json:"yellowMinScore"`
+	// Default is used for roads without any known superficial deposit codes.
+	Default GroupRule `json:"default"`
+	// Groups maps superficial deposit codes to thresholds.
+	Groups []GroupRule `json:"groups"`
+	// Snow holds the thresholds for snow cover, which apply to every group.
+	Snow SnowRule `json:"snow"`
+
+	groupByCode map[int]*GroupRule
+}
+
+// GroupRule holds the thresholds for a group of superficial deposits, e.g. moraine or peat.
+type GroupRule struct {
+	Name  string `json:"name"`
+	Codes []int  `json:"codes"`
+	// FrozenDepth is the frost depth in cm at which the ground carries traffic regardless of water saturation.
+	FrozenDepth float64 `json:"frozenDepth"`
+	// SaturationYellow is the water saturation in percent above which the road is no longer green.
+	SaturationYellow float64 `json:"saturationYellow"`
+	// SaturationRed is the water saturation in percent above which the road is red.
+	SaturationRed float64 `json:"saturationRed"`
+}
+
+// SnowRule holds the thresholds for snow cover on the road.
+type SnowRule struct {
+	// InsulatingDepth is the snow depth in cm at which the snow insulates the ground, so that frost no longer forms.
+	InsulatingDepth float64 `json:"insulatingDepth"`
+	// PloughDepth is the snow depth in cm above which the road must be ploughed, the road can not be green.
+	PloughDepth float64 `json:"ploughDepth"`
+	// MeltwaterEquivalent is the snow water equivalent in mm above which meltwater lowers the score of unfrozen ground.
+	MeltwaterEquivalent float64 `json:"meltwaterEquivalent"`
+	// MeltwaterPenalty is the score subtracted when the snow water equivalent is above MeltwaterEquivalent.
+	MeltwaterPenalty float64 `json:"meltwaterPenalty"`
+}
+
+// LoadRulesFromFile loads the trafficability rules from a JSON file and makes them the active rules.
+func LoadRulesFromFile() error {
+	data, err := os.ReadFile(_rulesFile)
+	if err != nil {
+		return err
+	}
+
+	var rules Rules
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return err
+	}
+
+	err = rules.validate()
+	if err != nil {
+		return fmt.Errorf("invalid rules in %s: %v", _rulesFile, err)
+	}
+
+	_rules = &rules
+	return nil
+}
+
+// validate checks the thresholds and builds the code lookup table.
+func (rules *Rules) validate() error {
+	if rules.YellowMinScore > rules.GreenMinScore {
+		return fmt.Errorf("yellowMinScore %.0f is larger than greenMinScore %.0f", rules.YellowMinScore, rules.GreenMinScore)
+	}
+
+	err := rules.Default.validate()
+	if err != nil {
+		return fmt.Errorf("default: %v", err)
+	}
+
+	if rules.Snow.InsulatingDepth <= 0 || rules.Snow.PloughDepth <= 0 || rules.Snow.MeltwaterEquivalent <= 0 {
+		return fmt.Errorf("snow: insulatingDepth, ploughDepth and meltwaterEquivalent must be positive")
+	}
+	if rules.Snow.MeltwaterPenalty < 0 || rules.Snow.MeltwaterPenalty > 100 {
+		return fmt.Errorf("snow: meltwaterPenalty must be from 0 to 100")
+	}
+
+	rules.groupByCode = make(map[int]*GroupRule)
+	for i := range rules.Groups {
+		group := &rules.Groups[i]
+		err = group.validate()
+		if err != nil {
+			return fmt.Errorf("group %s: %v", group.Name, err)
+		}
+
+		for _, code := range group.Codes {
+			if existing, ok := rules.groupByCode[code]; ok {
+				return fmt.Errorf("code %d is in both %s and %s", code, existing.Name, group.Name)
+			}
+			rules.groupByCode[code] = group
+		}
+	}
+
+	return nil
+}
+
+func (group *GroupRule) validate() error {
+	if group.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	if group.FrozenDepth <= 0 {
+		return fmt.Errorf("frozenDepth must be positive")
+	}
+	if group.SaturationYellow <= 0 || group.SaturationYellow >= group.SaturationRed {
+		return fmt.Errorf("saturationYellow must be positive and below saturationRed")
+	}
+
+	return nil
+}
+
+// groupForCode returns the group a superficial deposit code belongs to, or the default group.
+func (rules *Rules) groupForCode(code int) *GroupRule {
+	if group, ok := rules.groupByCode[code]; ok {
+		return group
+	}
+	return &rules.Default
+}
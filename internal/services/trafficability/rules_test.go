@@ -0,0 +1,39 @@
+package trafficability
+
+import "testing"
+
+func TestRulesValidateMeltwaterPenalty(t *testing.T) {
+	tests := []struct {
+		penalty float64
+		wantErr bool
+	}{
+		{penalty: 0},
+		{penalty: 15},
+		{penalty: 100},
+		{penalty: -1, wantErr: true},
+		{penalty: 101, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		rules := testRules()
+		rules.Snow.MeltwaterPenalty = tt.penalty
+		err := rules.validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validate() with meltwaterPenalty %v = %v, want error %v", tt.penalty, err, tt.wantErr)
+		}
+	}
+}
+
+// testRules returns rules like trafficability.json, with a moraine and a peat group.
+func testRules() Rules {
+	return Rules{
+		GreenMinScore:  70,
+		YellowMinScore: 40,
+		Default:        GroupRule{Name: "unknown", FrozenDepth: 25, SaturationYellow: 65, SaturationRed: 85},
+		Groups: []GroupRule{
+			{Name: "moraine", Codes: []int{11, 12}, FrozenDepth: 20, SaturationYellow: 70, SaturationRed: 90},
+			{Name: "peat", Codes: []int{90}, FrozenDepth: 40, SaturationYellow: 50, SaturationRed: 70},
+		},
+		Snow: SnowRule{InsulatingDepth: 30, PloughDepth: 50, MeltwaterEquivalent: 150, MeltwaterPenalty: 15},
+	}
+}
@@ -0,0 +1,182 @@
+// Package trafficability combines frost depth, water saturation and superficial deposits into a
+// per-road trafficability class.
+package trafficability
+
+import (
+	"fmt"
+	"math"
+	"skogkursbachelor/server/internal/models"
+)
+
+// Trafficability classes
+const (
+	ClassGreen  = "green"
+	ClassYellow = "yellow"
+	ClassRed    = "red"
+	// ClassUnknown is used when the data needed to classify the road is missing
+	ClassUnknown = "unknown"
+)
+
+// _rules are the active rules, set by LoadRulesFromFile
+var _rules *Rules
+
+// UpdateTrafficability sets the trafficability class, score and reasons on every road in the feature map.
+// Frost depth, water saturation, snow and superficial deposit codes must already be set on the roads.
+func UpdateTrafficability(featureMap *map[string][]models.ForestRoad) error {
+	if _rules == nil {
+		return fmt.Errorf("trafficability rules are not loaded")
+	}
+
+	for _, roads := range *featureMap {
+		for i := range roads {
+			class, score, reasons := _rules.classify(roads[i])
+			roads[i].Properties.Framkommelighetsklasse = class
+			roads[i].Properties.Framkommelighetsscore = score
+			roads[i].Properties.Framkommelighetsgrunner = reasons
+		}
+	}
+
+	return nil
+}
+
+// classify evaluates every deposit group along the road, and returns the class, score and reasons of the worst one.
+// Missing frost depth is treated as unfrozen ground, and missing snow as no snow. Without water saturation,
+// only frozen ground can be classified.
+func (rules *Rules) classify(road models.ForestRoad) (string, float64, []string) {
+	frostDepth := valueOrZero(road.Properties.Teledybde)
+	waterSaturation := road.Properties.Vannmetning
+
+	var groups []*GroupRule
+	seen := make(map[string]bool)
+	for _, code := range road.Properties.Løsmassekoder {
+		group := rules.groupForCode(code)
+		if !seen[group.Name] {
+			seen[group.Name] = true
+			groups = append(groups, group)
+		}
+	}
+	if len(groups) == 0 {
+		groups = append(groups, &rules.Default)
+	}
+
+	// Deep snow insulates the ground, so frost that has not reached the frozen depth will not grow
+	snowDepth := valueOrZero(road.Properties.Snødybde)
+	insulated := snowDepth >= rules.Snow.InsulatingDepth
+
+	worstScore := math.Inf(1)
+	var worstReason string
+	frozen := false
+	for _, group := range groups {
+		score, reason, ok := group.score(frostDepth, waterSaturation, insulated)
+		if !ok {
+			return ClassUnknown, 0, []string{reason}
+		}
+		if score < worstScore {
+			worstScore = score
+			worstReason = reason
+			frozen = frostDepth >= group.FrozenDepth
+		}
+	}
+
+	reasons := []string{worstReason}
+	if road.Properties.Teledybde == nil {
+		reasons = append(reasons, "no frost depth data, the ground is assumed to be unfrozen")
+	}
+	if len(groups) > 1 {
+		reasons = append(reasons, fmt.Sprintf("road crosses %d deposit groups, the weakest decides", len(groups)))
+	}
+
+	if insulated && !frozen {
+		reasons = append(reasons, fmt.Sprintf(
+			"snow depth %.0f cm insulates the ground, frost is not expected to form", snowDepth,
+		))
+	}
+
+	snowWaterEquivalent := valueOrZero(road.Properties.Snøvannekvivalent)
+	if !frozen && snowWaterEquivalent >= rules.Snow.MeltwaterEquivalent {
+		worstScore = math.Max(0, worstScore-rules.Snow.MeltwaterPenalty)
+		reasons = append(reasons, fmt.Sprintf(
+			"snowpack holds %.0f mm of water that will saturate unfrozen ground when it melts", snowWaterEquivalent,
+		))
+	}
+
+	if snowDepth > rules.Snow.PloughDepth && worstScore >= rules.GreenMinScore {
+		worstScore = rules.GreenMinScore - 1
+		reasons = append(reasons, fmt.Sprintf(
+			"snow depth %.0f cm is above %.0f cm, the road must be ploughed", snowDepth, rules.Snow.PloughDepth,
+		))
+	}
+
+	worstScore = math.Round(worstScore)
+	return rules.classForScore(worstScore), worstScore, reasons
+}
+
+// score returns a score between 0 and 100 for the group, and the reason for it.
+// Frozen ground scores 100, otherwise the score falls with water saturation, and is raised
+// partially by frost that has not yet reached the frozen depth of the group, unless snow insulates the ground.
+// Returns false if the ground is not frozen and the water saturation is missing.
+func (group *GroupRule) score(frostDepth float64, saturation *float64, insulated bool) (float64, string, bool) {
+	if frostDepth >= group.FrozenDepth {
+		return 100, fmt.Sprintf(
+			"%s: frost depth %.0f cm is at least %.0f cm, ground is frozen",
+			group.Name, frostDepth, group.FrozenDepth,
+		), true
+	}
+
+	if saturation == nil {
+		return 0, fmt.Sprintf("%s: ground is not frozen, and there is no water saturation data", group.Name), false
+	}
+	waterSaturation := *saturation
+
+	var score float64
+	var reason string
+	switch {
+	case waterSaturation <= group.SaturationYellow:
+		score = 100 - 30*waterSaturation/group.SaturationYellow
+		reason = fmt.Sprintf(
+			"%s: water saturation %.0f%% is below %.0f%%",
+			group.Name, waterSaturation, group.SaturationYellow,
+		)
+	case waterSaturation < group.SaturationRed:
+		score = 70 - 30*(waterSaturation-group.SaturationYellow)/(group.SaturationRed-group.SaturationYellow)
+		reason = fmt.Sprintf(
+			"%s: water saturation %.0f%% is between %.0f%% and %.0f%%",
+			group.Name, waterSaturation, group.SaturationYellow, group.SaturationRed,
+		)
+	default:
+		score = 40
+		if group.SaturationRed < 100 {
+			score = 40 - 40*(waterSaturation-group.SaturationRed)/(100-group.SaturationRed)
+		}
+		reason = fmt.Sprintf(
+			"%s: water saturation %.0f%% is at or above %.0f%%",
+			group.Name, waterSaturation, group.SaturationRed,
+		)
+	}
+
+	if frostDepth > 0 && !insulated {
+		score += (100 - score) * frostDepth / group.FrozenDepth / 2
+		reason += fmt.Sprintf(", partly frozen to %.0f cm", frostDepth)
+	}
+
+	return math.Max(0, math.Min(100, score)), reason, true
+}
+
+// classForScore maps a score to a trafficability class.
+func (rules *Rules) classForScore(score float64) string {
+	switch {
+	case score >= rules.GreenMinScore:
+		return ClassGreen
+	case score >= rules.YellowMinScore:
+		return ClassYellow
+	default:
+		return ClassRed
+	}
+}
+
+func valueOrZero(value *float64) float64 {
+	if value == nil {
+		return 0
+	}
+	return *value
+}
@@ -0,0 +1,144 @@
+package trafficability
+
+import (
+	"skogkursbachelor/server/internal/models"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestUpdateTrafficability(t *testing.T) {
+	rules := testRules()
+	if err := rules.validate(); err != nil {
+		t.Fatalf("validate() returned %v", err)
+	}
+	defer func(previous *Rules) { _rules = previous }(_rules)
+	_rules = &rules
+
+	tests := []struct {
+		name       string
+		properties models.ForestRoadProperties
+		wantClass  string
+		wantScore  float64
+		// wantReason is the start of one of the reasons
+		wantReason string
+	}{
+		{
+			name:       "dry moraine",
+			properties: models.ForestRoadProperties{Løsmassekoder: []int{11}, Teledybde: float(0), Vannmetning: float(35)},
+			wantClass:  ClassGreen, wantScore: 85, wantReason: "moraine: water saturation 35% is below 70%",
+		},
+		{
+			name:       "missing frost depth is unfrozen",
+			properties: models.ForestRoadProperties{Løsmassekoder: []int{11}, Vannmetning: float(35)},
+			wantClass:  ClassGreen, wantScore: 85, wantReason: "no frost depth data",
+		},
+		{
+			name:       "weakest deposit decides",
+			properties: models.ForestRoadProperties{Løsmassekoder: []int{11, 12, 90}, Teledybde: float(0), Vannmetning: float(60)},
+			wantClass:  ClassYellow, wantScore: 55, wantReason: "peat: water saturation 60% is between 50% and 70%",
+		},
+		{
+			name:       "crossing deposit groups is a reason",
+			properties: models.ForestRoadProperties{Løsmassekoder: []int{11, 90}, Teledybde: float(0), Vannmetning: float(60)},
+			wantClass:  ClassYellow, wantScore: 55, wantReason: "road crosses 2 deposit groups",
+		},
+		{
+			name:       "unknown code uses the default group",
+			properties: models.ForestRoadProperties{Løsmassekoder: []int{999}, Teledybde: float(0), Vannmetning: float(90)},
+			wantClass:  ClassRed, wantScore: 27, wantReason: "unknown: water saturation 90% is at or above 85%",
+		},
+		{
+			name:       "no codes uses the default group",
+			properties: models.ForestRoadProperties{Teledybde: float(0), Vannmetning: float(90)},
+			wantClass:  ClassRed, wantScore: 27, wantReason: "unknown:",
+		},
+		{
+			name:       "frozen without water saturation",
+			properties: models.ForestRoadProperties{Løsmassekoder: []int{11}, Teledybde: float(25)},
+			wantClass:  ClassGreen, wantScore: 100, wantReason: "moraine: frost depth 25 cm is at least 20 cm",
+		},
+		{
+			name:       "unfrozen without water saturation is unknown",
+			properties: models.ForestRoadProperties{Løsmassekoder: []int{11}, Teledybde: float(5)},
+			wantClass:  ClassUnknown, wantScore: 0, wantReason: "moraine: ground is not frozen, and there is no water saturation data",
+		},
+		{
+			name:       "one unfrozen group without water saturation is unknown",
+			properties: models.ForestRoadProperties{Løsmassekoder: []int{11, 90}, Teledybde: float(25)},
+			wantClass:  ClassUnknown, wantScore: 0, wantReason: "peat: ground is not frozen",
+		},
+		{
+			name:       "no data is unknown",
+			properties: models.ForestRoadProperties{},
+			wantClass:  ClassUnknown, wantScore: 0, wantReason: "unknown: ground is not frozen",
+		},
+		{
+			name:       "partly frozen",
+			properties: models.ForestRoadProperties{Løsmassekoder: []int{11}, Teledybde: float(10), Vannmetning: float(35)},
+			wantClass:  ClassGreen, wantScore: 89, wantReason: "moraine: water saturation 35% is below 70%, partly frozen to 10 cm",
+		},
+		{
+			name: "insulating snow",
+			properties: models.ForestRoadProperties{
+				Løsmassekoder: []int{11}, Teledybde: float(10), Vannmetning: float(35), Snødybde: float(40),
+			},
+			wantClass: ClassGreen, wantScore: 85, wantReason: "snow depth 40 cm insulates the ground",
+		},
+		{
+			name: "deep snow must be ploughed",
+			properties: models.ForestRoadProperties{
+				Løsmassekoder: []int{11}, Teledybde: float(0), Vannmetning: float(0), Snødybde: float(60),
+			},
+			wantClass: ClassYellow, wantScore: 69, wantReason: "snow depth 60 cm is above 50 cm",
+		},
+		{
+			name: "meltwater on unfrozen ground",
+			properties: models.ForestRoadProperties{
+				Løsmassekoder: []int{11}, Teledybde: float(0), Vannmetning: float(42), Snøvannekvivalent: float(200),
+			},
+			wantClass: ClassYellow, wantScore: 67, wantReason: "snowpack holds 200 mm of water",
+		},
+		{
+			name: "no meltwater penalty on frozen ground",
+			properties: models.ForestRoadProperties{
+				Løsmassekoder: []int{11}, Teledybde: float(25), Snøvannekvivalent: float(200),
+			},
+			wantClass: ClassGreen, wantScore: 100, wantReason: "moraine: frost depth 25 cm",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			featureMap := map[string][]models.ForestRoad{"262000,6649000": {{Properties: tt.properties}}}
+			if err := UpdateTrafficability(&featureMap); err != nil {
+				t.Fatalf("UpdateTrafficability returned %v", err)
+			}
+
+			properties := featureMap["262000,6649000"][0].Properties
+			if properties.Framkommelighetsklasse != tt.wantClass || properties.Framkommelighetsscore != tt.wantScore {
+				t.Errorf("class, score = %s, %v, want %s, %v",
+					properties.Framkommelighetsklasse, properties.Framkommelighetsscore, tt.wantClass, tt.wantScore)
+			}
+			if !slices.ContainsFunc(properties.Framkommelighetsgrunner, func(reason string) bool {
+				return strings.HasPrefix(reason, tt.wantReason)
+			}) {
+				t.Errorf("reasons = %q, want one starting with %q", properties.Framkommelighetsgrunner, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestUpdateTrafficabilityWithoutRules(t *testing.T) {
+	defer func(previous *Rules) { _rules = previous }(_rules)
+	_rules = nil
+
+	featureMap := map[string][]models.ForestRoad{}
+	if err := UpdateTrafficability(&featureMap); err == nil {
+		t.Errorf("UpdateTrafficability without rules returned no error")
+	}
+}
+
+func float(value float64) *float64 {
+	return &value
+}
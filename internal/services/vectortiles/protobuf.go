@@ -0,0 +1,57 @@
+package vectortiles
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Protocol buffer wire types used by the vector tile spec
+const (
+	_wireVarint          = 0
+	_wireFixed64         = 1
+	_wireLengthDelimited = 2
+)
+
+// protoBuffer writes protocol buffer fields, only what the vector tile spec needs.
+// See https://protobuf.dev/programming-guides/encoding/
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) key(field int, wireType int) {
+	b.data = binary.AppendUvarint(b.data, uint64(field<<3|wireType))
+}
+
+func (b *protoBuffer) varint(field int, value uint64) {
+	b.key(field, _wireVarint)
+	b.data = binary.AppendUvarint(b.data, value)
+}
+
+func (b *protoBuffer) double(field int, value float64) {
+	b.key(field, _wireFixed64)
+	b.data = binary.LittleEndian.AppendUint64(b.data, math.Float64bits(value))
+}
+
+func (b *protoBuffer) bytes(field int, value []byte) {
+	b.key(field, _wireLengthDelimited)
+	b.data = binary.AppendUvarint(b.data, uint64(len(value)))
+	b.data = append(b.data, value...)
+}
+
+func (b *protoBuffer) string(field int, value string) {
+	b.bytes(field, []byte(value))
+}
+
+// packed writes a packed repeated field of unsigned integers.
+func (b *protoBuffer) packed(field int, values []uint32) {
+	var packed []byte
+	for _, value := range values {
+		packed = binary.AppendUvarint(packed, uint64(value))
+	}
+	b.bytes(field, packed)
+}
+
+// zigzag encodes a signed integer so small negative numbers are small varints.
+func zigzag(n int32) uint32 {
+	return uint32((n << 1) ^ (n >> 31))
+}
@@ -0,0 +1,276 @@
+// Package vectortiles encodes enriched forestry roads as Mapbox Vector Tiles, in the Web Mercator tile grid.
+// See https://github.com/mapbox/vector-tile-spec/tree/master/2.1
+package vectortiles
+
+import (
+	"math"
+	"skogkursbachelor/server/internal/models"
+	"skogkursbachelor/server/internal/projection"
+	"skogkursbachelor/server/internal/utils"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LayerName is the name of the layer of forestry roads in the tiles
+const LayerName = "forestryroads"
+
+// _extent is the size of a tile in tile coordinates
+const _extent = 4096
+
+// _simplifyTolerance is how far, in tile coordinates, a simplified line may be from the road.
+// It is half a pixel of a 512 pixel tile, so it is a longer distance on the ground the further out the zoom is.
+const _simplifyTolerance = 4.0
+
+// _utmZone is the UTM zone of the roads, EPSG:25833
+const _utmZone = 33
+
+// Geometry types and commands of the vector tile spec
+const (
+	_lineStringType = 2
+	_moveTo         = 1
+	_lineTo         = 2
+)
+
+// attribute is a tag of a feature, the value is a string, float64, int64 or bool.
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+// layer collects the features of a layer, with the keys and values they share.
+type layer struct {
+	features   protoBuffer
+	keys       []string
+	keyIndex   map[string]uint32
+	values     []interface{}
+	valueIndex map[interface{}]uint32
+}
+
+// EncodeRoads encodes the roads, in EPSG:25833, as the vector tile at z/x/y. Lines are simplified for the zoom level,
+// and roads shorter than a pixel are left out. Returns an empty tile, nil, if no roads are in the tile.
+func EncodeRoads(roads []models.ForestRoad, z, x, y int) []byte {
+	minX, _, maxX, maxY := projection.TileBounds(z, x, y)
+	scale := _extent / (maxX - minX)
+
+	tileLayer := &layer{keyIndex: make(map[string]uint32), valueIndex: make(map[interface{}]uint32)}
+	for i, road := range roads {
+		points := make([][2]float64, len(road.Geometry.Coordinates))
+		for j, coordinate := range road.Geometry.Coordinates {
+			lon, lat := projection.UTMToLonLat(coordinate[0], coordinate[1], _utmZone)
+			mercatorX, mercatorY := projection.LonLatToWebMercator(lon, lat)
+			points[j] = [2]float64{(mercatorX - minX) * scale, (maxY - mercatorY) * scale}
+		}
+
+		geometry := encodeLine(simplify(points, _simplifyTolerance))
+		if geometry == nil {
+			continue
+		}
+
+		tileLayer.addFeature(uint64(i+1), geometry, attributes(road.Properties))
+	}
+
+	if tileLayer.features.data == nil {
+		return nil
+	}
+	return tileLayer.encode()
+}
+
+// addFeature adds a line feature to the layer.
+func (l *layer) addFeature(id uint64, geometry []uint32, attributes []attribute) {
+	tags := make([]uint32, 0, 2*len(attributes))
+	for _, a := range attributes {
+		keyIndex, ok := l.keyIndex[a.key]
+		if !ok {
+			keyIndex = uint32(len(l.keys))
+			l.keyIndex[a.key] = keyIndex
+			l.keys = append(l.keys, a.key)
+		}
+
+		valueIndex, ok := l.valueIndex[a.value]
+		if !ok {
+			valueIndex = uint32(len(l.values))
+			l.valueIndex[a.value] = valueIndex
+			l.values = append(l.values, a.value)
+		}
+
+		tags = append(tags, keyIndex, valueIndex)
+	}
+
+	var feature protoBuffer
+	feature.varint(1, id)
+	feature.packed(2, tags)
+	feature.varint(3, _lineStringType)
+	feature.packed(4, geometry)
+
+	l.features.bytes(2, feature.data)
+}
+
+// encode encodes the layer as a tile with a single layer.
+func (l *layer) encode() []byte {
+	var encoded protoBuffer
+	encoded.varint(15, 2)
+	encoded.string(1, LayerName)
+	encoded.data = append(encoded.data, l.features.data...)
+	for _, key := range l.keys {
+		encoded.string(3, key)
+	}
+	for _, value := range l.values {
+		var v protoBuffer
+		switch value := value.(type) {
+		case string:
+			v.string(1, value)
+		case float64:
+			v.double(3, value)
+		case int64:
+			v.varint(4, uint64(value))
+		case bool:
+			v.varint(7, boolToUint(value))
+		}
+		encoded.bytes(4, v.data)
+	}
+	encoded.varint(5, _extent)
+
+	var tile protoBuffer
+	tile.bytes(3, encoded.data)
+	return tile.data
+}
+
+// encodeLine encodes the points as line geometry commands, rounded to tile coordinates.
+// Returns nil if the line is shorter than a tile coordinate.
+func encodeLine(points [][2]float64) []uint32 {
+	rounded := make([][2]int32, 0, len(points))
+	for _, point := range points {
+		p := [2]int32{int32(math.Round(point[0])), int32(math.Round(point[1]))}
+		if len(rounded) > 0 && rounded[len(rounded)-1] == p {
+			continue
+		}
+		rounded = append(rounded, p)
+	}
+	if len(rounded) < 2 {
+		return nil
+	}
+
+	geometry := make([]uint32, 0, 2*len(rounded)+2)
+	geometry = append(geometry, _moveTo|1<<3, zigzag(rounded[0][0]), zigzag(rounded[0][1]))
+	geometry = append(geometry, _lineTo|uint32(len(rounded)-1)<<3)
+	for i := 1; i < len(rounded); i++ {
+		geometry = append(geometry, zigzag(rounded[i][0]-rounded[i-1][0]), zigzag(rounded[i][1]-rounded[i-1][1]))
+	}
+
+	return geometry
+}
+
+// simplify simplifies a line with the Douglas-Peucker algorithm, keeping the points further than the tolerance
+// from the simplified line.
+func simplify(points [][2]float64, tolerance float64) [][2]float64 {
+	if len(points) < 3 {
+		return points
+	}
+
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+	simplifySection(points, 0, len(points)-1, tolerance, keep)
+
+	simplified := make([][2]float64, 0, len(points))
+	for i, point := range points {
+		if keep[i] {
+			simplified = append(simplified, point)
+		}
+	}
+	return simplified
+}
+
+// simplifySection marks the point between first and last that is furthest from the line between them,
+// if it is further than the tolerance, and simplifies the sections on each side of it.
+func simplifySection(points [][2]float64, first, last int, tolerance float64, keep []bool) {
+	furthest, furthestDistance := 0, tolerance
+	for i := first + 1; i < last; i++ {
+		distance := utils.SegmentDistance(points[i], points[first], points[last])
+		if distance > furthestDistance {
+			furthest, furthestDistance = i, distance
+		}
+	}
+
+	if furthest == 0 {
+		return
+	}
+
+	keep[furthest] = true
+	simplifySection(points, first, furthest, tolerance, keep)
+	simplifySection(points, furthest, last, tolerance, keep)
+}
+
+// attributes returns the tile attributes of a road. Null properties are left out, as tiles have no null values.
+// Lists are joined to strings, and the deposit the longest part of the road is on is added as løsmassekode.
+func attributes(properties models.ForestRoadProperties) []attribute {
+	var attributes []attribute
+	addString := func(key, value string) {
+		if value != "" {
+			attributes = append(attributes, attribute{key, value})
+		}
+	}
+	addFloat := func(key string, value *float64) {
+		if value != nil {
+			attributes = append(attributes, attribute{key, *value})
+		}
+	}
+
+	addString("kommunenummer", properties.Kommunenummer)
+	addString("vegkategori", properties.Vegkategori)
+	addString("vegfase", properties.Vegfase)
+	addString("vegnummer", properties.Vegnummer)
+	addString("strekningnummer", properties.Strekningnummer)
+	addString("delstrekningnummer", properties.Delstrekningnummer)
+
+	addFloat("teledybde", properties.Teledybde)
+	addFloat("vannmetning", properties.Vannmetning)
+	addFloat("snødybde", properties.Snødybde)
+	addFloat("snøvannekvivalent", properties.Snøvannekvivalent)
+	attributes = append(attributes,
+		attribute{"teledybdeerprognose", properties.Teledybdeerprognose},
+		attribute{"vannmetningerprognose", properties.Vannmetningerprognose},
+	)
+
+	codes := make([]string, len(properties.Løsmassekoder))
+	for i, code := range properties.Løsmassekoder {
+		codes[i] = strconv.Itoa(code)
+	}
+	addString("løsmassekoder", strings.Join(codes, ","))
+	if segment, ok := properties.DominantDeposit(); ok {
+		attributes = append(attributes, attribute{"løsmassekode", int64(segment.Kode)})
+		addString("løsmassenavn", segment.Navn)
+	}
+	attributes = append(attributes, attribute{"erklyngesenterundervann", properties.Erklyngesenterundervann})
+
+	if properties.Framkommelighetsklasse != "" {
+		addString("framkommelighetsklasse", properties.Framkommelighetsklasse)
+		attributes = append(attributes, attribute{"framkommelighetsscore", properties.Framkommelighetsscore})
+	}
+
+	// Values of configured SeNorge themes, series are left out
+	keys := make([]string, 0, len(properties.Ekstra))
+	for key := range properties.Ekstra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch value := properties.Ekstra[key].(type) {
+		case *float64:
+			addFloat(key, value)
+		case float64, bool:
+			attributes = append(attributes, attribute{key, value})
+		case string:
+			addString(key, value)
+		}
+	}
+
+	return attributes
+}
+
+func boolToUint(value bool) uint64 {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,315 @@
+package vectortiles
+
+import (
+	"encoding/binary"
+	"math"
+	"skogkursbachelor/server/internal/models"
+	"skogkursbachelor/server/internal/projection"
+	"slices"
+	"testing"
+)
+
+// decodedFeature is a feature read back from a tile, with its tags resolved to keys and values.
+type decodedFeature struct {
+	id           uint64
+	geometryType uint64
+	geometry     []uint32
+	attributes   map[string]interface{}
+}
+
+// decodedLayer is a layer read back from a tile.
+type decodedLayer struct {
+	version, extent uint64
+	name            string
+	features        []decodedFeature
+	keys            []string
+	values          []interface{}
+}
+
+func TestEncodeRoads(t *testing.T) {
+	// A tile in Oslo, the roads are placed at tile coordinates
+	const z, x, y = 14, 8681, 4763
+	at := tileCoordinates(z, x, y)
+
+	teledybde := 12.5
+	first := models.ForestRoad{Properties: models.ForestRoadProperties{
+		Vegnummer:           "1234",
+		Teledybde:           &teledybde,
+		Teledybdeerprognose: true,
+		Løsmassekoder:       []int{11, 90},
+		Løsmassesegmenter: []models.DepositSegment{
+			{FraMeter: 0, TilMeter: 10, Kode: 11, Navn: "Morene"},
+			{FraMeter: 10, TilMeter: 100, Kode: 90, Navn: "Torv"},
+		},
+		Framkommelighetsklasse: "green",
+		Framkommelighetsscore:  85,
+	}}
+	first.Geometry.Coordinates = [][]float64{at(1000, 1000), at(1100, 900), at(1050, 1200)}
+
+	// Shorter than a tile coordinate, left out
+	short := models.ForestRoad{Properties: models.ForestRoadProperties{Vegnummer: "1"}}
+	short.Geometry.Coordinates = [][]float64{at(2000, 2000), at(2000.2, 2000.1)}
+
+	third := models.ForestRoad{Properties: models.ForestRoadProperties{Vegnummer: "1234"}}
+	third.Geometry.Coordinates = [][]float64{at(-10, 4100), at(10, 4090)}
+
+	layer := decodeTile(t, EncodeRoads([]models.ForestRoad{first, short, third}, z, x, y))
+
+	if layer.name != LayerName || layer.version != 2 || layer.extent != _extent {
+		t.Errorf("layer %s, version %d, extent %d, want %s, 2, %d", layer.name, layer.version, layer.extent, LayerName, _extent)
+	}
+	if len(layer.features) != 2 {
+		t.Fatalf("tile has %d features, want 2", len(layer.features))
+	}
+
+	feature := layer.features[0]
+	if feature.id != 1 || feature.geometryType != _lineStringType {
+		t.Errorf("feature id %d, type %d, want 1, %d", feature.id, feature.geometryType, _lineStringType)
+	}
+	// MoveTo 1000,1000, then LineTo +100,-100 and -50,+300, zigzag encoded
+	wantGeometry := []uint32{9, 2000, 2000, 18, 200, 199, 99, 600}
+	if !slices.Equal(feature.geometry, wantGeometry) {
+		t.Errorf("geometry = %v, want %v", feature.geometry, wantGeometry)
+	}
+
+	wantAttributes := map[string]interface{}{
+		"vegnummer":               "1234",
+		"teledybde":               12.5,
+		"teledybdeerprognose":     true,
+		"vannmetningerprognose":   false,
+		"løsmassekoder":           "11,90",
+		"løsmassekode":            int64(90),
+		"løsmassenavn":            "Torv",
+		"erklyngesenterundervann": false,
+		"framkommelighetsklasse":  "green",
+		"framkommelighetsscore":   85.0,
+	}
+	checkAttributes(t, feature.attributes, wantAttributes)
+
+	// Null and empty properties have no tags
+	for _, key := range []string{"vannmetning", "snødybde", "snøvannekvivalent", "kommunenummer"} {
+		if _, ok := feature.attributes[key]; ok {
+			t.Errorf("null property %s has a tag", key)
+		}
+	}
+
+	feature = layer.features[1]
+	if feature.id != 3 {
+		t.Errorf("feature id %d, want 3", feature.id)
+	}
+	// Outside the tile, at -10,4100, in the buffer
+	wantGeometry = []uint32{9, 19, 8200, 10, 40, 19}
+	if !slices.Equal(feature.geometry, wantGeometry) {
+		t.Errorf("geometry = %v, want %v", feature.geometry, wantGeometry)
+	}
+	if feature.attributes["vegnummer"] != "1234" {
+		t.Errorf("vegnummer = %v, want 1234", feature.attributes["vegnummer"])
+	}
+
+	// Keys and values are shared by the features
+	for i := range layer.values {
+		if slices.Index(layer.values, layer.values[i]) != i {
+			t.Errorf("value %v is in the value table more than once", layer.values[i])
+		}
+	}
+	for i := range layer.keys {
+		if slices.Index(layer.keys, layer.keys[i]) != i {
+			t.Errorf("key %s is in the key table more than once", layer.keys[i])
+		}
+	}
+}
+
+func TestEncodeRoadsEmpty(t *testing.T) {
+	road := models.ForestRoad{}
+	road.Geometry.Coordinates = [][]float64{{262000, 6649000}, {262000.1, 6649000}}
+
+	if tile := EncodeRoads([]models.ForestRoad{road}, 14, 8681, 4763); tile != nil {
+		t.Errorf("EncodeRoads without visible roads = %v, want nil", tile)
+	}
+}
+
+func TestZigzag(t *testing.T) {
+	tests := []struct {
+		n    int32
+		want uint32
+	}{
+		{n: 0, want: 0},
+		{n: -1, want: 1},
+		{n: 1, want: 2},
+		{n: -2, want: 3},
+		{n: 2, want: 4},
+		{n: math.MaxInt32, want: math.MaxUint32 - 1},
+		{n: math.MinInt32, want: math.MaxUint32},
+	}
+
+	for _, tt := range tests {
+		if got := zigzag(tt.n); got != tt.want {
+			t.Errorf("zigzag(%d) = %d, want %d", tt.n, got, tt.want)
+		}
+	}
+}
+
+// tileCoordinates returns a function giving the EPSG:25833 coordinate of a tile coordinate of the tile.
+func tileCoordinates(z, x, y int) func(tileX, tileY float64) []float64 {
+	minX, _, maxX, maxY := projection.TileBounds(z, x, y)
+	size := maxX - minX
+	return func(tileX, tileY float64) []float64 {
+		lon, lat := projection.WebMercatorToLonLat(minX+tileX*size/_extent, maxY-tileY*size/_extent)
+		easting, northing := projection.LonLatToUTM(lon, lat, _utmZone)
+		return []float64{easting, northing}
+	}
+}
+
+func checkAttributes(t *testing.T, got, want map[string]interface{}) {
+	t.Helper()
+
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %v (%T), want %v (%T)", key, got[key], got[key], value, value)
+		}
+	}
+	for key := range got {
+		if _, ok := want[key]; !ok {
+			t.Errorf("unexpected tag %s = %v", key, got[key])
+		}
+	}
+}
+
+// decodeTile decodes a tile with a single layer.
+func decodeTile(t *testing.T, data []byte) decodedLayer {
+	t.Helper()
+
+	var layers [][]byte
+	readFields(t, data, func(field int, value uint64, bytes []byte) {
+		if field == 3 {
+			layers = append(layers, bytes)
+		}
+	})
+	if len(layers) != 1 {
+		t.Fatalf("tile has %d layers, want 1", len(layers))
+	}
+
+	var layer decodedLayer
+	var features [][]byte
+	readFields(t, layers[0], func(field int, value uint64, bytes []byte) {
+		switch field {
+		case 15:
+			layer.version = value
+		case 1:
+			layer.name = string(bytes)
+		case 2:
+			features = append(features, bytes)
+		case 3:
+			layer.keys = append(layer.keys, string(bytes))
+		case 4:
+			layer.values = append(layer.values, decodeValue(t, bytes))
+		case 5:
+			layer.extent = value
+		}
+	})
+
+	for _, data := range features {
+		feature := decodedFeature{attributes: make(map[string]interface{})}
+		var tags []uint32
+		readFields(t, data, func(field int, value uint64, bytes []byte) {
+			switch field {
+			case 1:
+				feature.id = value
+			case 2:
+				tags = readPacked(t, bytes)
+			case 3:
+				feature.geometryType = value
+			case 4:
+				feature.geometry = readPacked(t, bytes)
+			}
+		})
+
+		if len(tags)%2 != 0 {
+			t.Fatalf("feature %d has an odd number of tags", feature.id)
+		}
+		for i := 0; i < len(tags); i += 2 {
+			if int(tags[i]) >= len(layer.keys) || int(tags[i+1]) >= len(layer.values) {
+				t.Fatalf("feature %d has a tag outside the key or value table", feature.id)
+			}
+			feature.attributes[layer.keys[tags[i]]] = layer.values[tags[i+1]]
+		}
+		layer.features = append(layer.features, feature)
+	}
+
+	return layer
+}
+
+// decodeValue decodes a value message, with one of the string, double, int or bool fields.
+func decodeValue(t *testing.T, data []byte) interface{} {
+	var value interface{}
+	readFields(t, data, func(field int, number uint64, bytes []byte) {
+		switch field {
+		case 1:
+			value = string(bytes)
+		case 3:
+			value = math.Float64frombits(number)
+		case 4:
+			value = int64(number)
+		case 7:
+			value = number == 1
+		default:
+			t.Errorf("unexpected value field %d", field)
+		}
+	})
+	return value
+}
+
+// readFields calls read with every field of the message, with the number of varint and fixed64 fields,
+// and the bytes of length delimited fields.
+func readFields(t *testing.T, data []byte, read func(field int, value uint64, bytes []byte)) {
+	t.Helper()
+
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			t.Fatalf("invalid field key")
+		}
+		data = data[n:]
+
+		field := int(key >> 3)
+		switch key & 7 {
+		case _wireVarint:
+			value, n := binary.Uvarint(data)
+			if n <= 0 {
+				t.Fatalf("invalid varint in field %d", field)
+			}
+			data = data[n:]
+			read(field, value, nil)
+		case _wireFixed64:
+			if len(data) < 8 {
+				t.Fatalf("short fixed64 in field %d", field)
+			}
+			read(field, binary.LittleEndian.Uint64(data), nil)
+			data = data[8:]
+		case _wireLengthDelimited:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				t.Fatalf("invalid length of field %d", field)
+			}
+			read(field, 0, data[n:n+int(length)])
+			data = data[n+int(length):]
+		default:
+			t.Fatalf("unexpected wire type %d in field %d", key&7, field)
+		}
+	}
+}
+
+func readPacked(t *testing.T, data []byte) []uint32 {
+	t.Helper()
+
+	var values []uint32
+	for len(data) > 0 {
+		value, n := binary.Uvarint(data)
+		if n <= 0 {
+			t.Fatalf("invalid packed varint")
+		}
+		values = append(values, uint32(value))
+		data = data[n:]
+	}
+	return values
+}
@@ -0,0 +1,176 @@
+package wms
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"skogkursbachelor/server/internal/projection"
+	"strconv"
+	"text/template"
+)
+
+// Version is the WMS version the server implements
+const Version = "1.3.0"
+
+// LayerName is the name of the layer of forestry roads
+const LayerName = "forestryroads"
+
+// MaxSize is the largest width and height of a map image, in pixels
+const MaxSize = 4096
+
+// MaxScaleDenominator is the most zoomed out scale roads are drawn at, further out a map covers too many roads
+// to enrich. At 0.28 mm per pixel it is about 70 meters per pixel, a 700 pixel map covers 50 km.
+const MaxScaleDenominator = 250000
+
+// _firstDate is the first date SeNorge has data for
+const _firstDate = "1957-01-01"
+
+// boundingBox is the extent of the layer in a CRS, in the axis order of the CRS.
+type boundingBox struct {
+	CRS                    string
+	MinX, MinY, MaxX, MaxY string
+}
+
+var _capabilitiesTemplate = template.Must(template.New("capabilities").Funcs(template.FuncMap{"xml": escapeXML}).Parse(
+	`<?xml version="1.0" encoding="UTF-8"?>
+<WMS_Capabilities version="{{.Version}}" xmlns="http://www.opengis.net/wms" xmlns:xlink="http://www.w3.org/1999/xlink" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.opengis.net/wms http://schemas.opengis.net/wms/1.3.0/capabilities_1_3_0.xsd">
+  <Service>
+    <Name>WMS</Name>
+    <Title>Skogkurs forestry roads</Title>
+    <Abstract>Forestry roads enriched with frost depth and water saturation from SeNorge, superficial deposits from NGU, and trafficability.</Abstract>
+    <OnlineResource xlink:type="simple" xlink:href="{{xml .URL}}"/>
+    <MaxWidth>{{.MaxSize}}</MaxWidth>
+    <MaxHeight>{{.MaxSize}}</MaxHeight>
+  </Service>
+  <Capability>
+    <Request>
+      <GetCapabilities>
+        <Format>text/xml</Format>
+        <DCPType><HTTP><Get><OnlineResource xlink:type="simple" xlink:href="{{xml .URL}}?"/></Get></HTTP></DCPType>
+      </GetCapabilities>
+      <GetMap>
+        <Format>image/png</Format>
+        <DCPType><HTTP><Get><OnlineResource xlink:type="simple" xlink:href="{{xml .URL}}?"/></Get></HTTP></DCPType>
+      </GetMap>
+      <GetFeatureInfo>
+        <Format>application/json</Format>
+        <Format>text/plain</Format>
+        <DCPType><HTTP><Get><OnlineResource xlink:type="simple" xlink:href="{{xml .URL}}?"/></Get></HTTP></DCPType>
+      </GetFeatureInfo>
+    </Request>
+    <Exception>
+      <Format>XML</Format>
+    </Exception>
+    <Layer queryable="1">
+      <Name>{{.LayerName}}</Name>
+      <Title>Skogsbilveger</Title>
+      <Abstract>Forestry roads, colored by the style. Roads without data for the style are grey.</Abstract>
+{{- range .BoundingBoxes}}
+      <CRS>{{.CRS}}</CRS>
+{{- end}}
+      <EX_GeographicBoundingBox>
+        <westBoundLongitude>{{.West}}</westBoundLongitude>
+        <eastBoundLongitude>{{.East}}</eastBoundLongitude>
+        <southBoundLatitude>{{.South}}</southBoundLatitude>
+        <northBoundLatitude>{{.North}}</northBoundLatitude>
+      </EX_GeographicBoundingBox>
+{{- range .BoundingBoxes}}
+      <BoundingBox CRS="{{.CRS}}" minx="{{.MinX}}" miny="{{.MinY}}" maxx="{{.MaxX}}" maxy="{{.MaxY}}"/>
+{{- end}}
+      <Dimension name="time" units="ISO8601" default="{{.DefaultDate}}" nearestValue="0">{{.FirstDate}}/{{.LastDate}}/P1D</Dimension>
+{{- range .Styles}}
+      <Style>
+        <Name>{{xml .Name}}</Name>
+        <Title>{{xml .Title}}</Title>
+        <Abstract>{{xml .Abstract}}</Abstract>
+      </Style>
+{{- end}}
+      <MaxScaleDenominator>{{.MaxScaleDenominator}}</MaxScaleDenominator>
+    </Layer>
+  </Capability>
+</WMS_Capabilities>
+`))
+
+// Capabilities returns the capabilities document of the service at the URL. Maps can be requested for the dates
+// from the first date SeNorge has data for to the last date, and are for the default date if no time is given.
+func Capabilities(url, defaultDate, lastDate string) ([]byte, error) {
+	geographic, err := projection.ParseCRS("CRS:84")
+	if err != nil {
+		return nil, err
+	}
+	minX, minY, maxX, maxY := geographic.BoundsToInternal(
+		projection.WestBoundLongitude, projection.SouthBoundLatitude, projection.EastBoundLongitude, projection.NorthBoundLatitude,
+	)
+
+	var boundingBoxes []boundingBox
+	for _, code := range projection.SupportedCRS() {
+		crs, err := projection.ParseCRS(code)
+		if err != nil {
+			return nil, err
+		}
+
+		crsMinX, crsMinY, crsMaxX, crsMaxY := crs.BoundsFromInternal(minX, minY, maxX, maxY)
+		if crs.LatLon {
+			crsMinX, crsMinY, crsMaxX, crsMaxY = crsMinY, crsMinX, crsMaxY, crsMaxX
+		}
+		boundingBoxes = append(boundingBoxes, boundingBox{
+			CRS:  crs.Code,
+			MinX: formatNumber(crsMinX, crs.Geographic),
+			MinY: formatNumber(crsMinY, crs.Geographic),
+			MaxX: formatNumber(crsMaxX, crs.Geographic),
+			MaxY: formatNumber(crsMaxY, crs.Geographic),
+		})
+	}
+
+	var buffer bytes.Buffer
+	err = _capabilitiesTemplate.Execute(&buffer, map[string]interface{}{
+		"Version":             Version,
+		"URL":                 url,
+		"MaxSize":             MaxSize,
+		"LayerName":           LayerName,
+		"BoundingBoxes":       boundingBoxes,
+		"West":                projection.WestBoundLongitude,
+		"East":                projection.EastBoundLongitude,
+		"South":               projection.SouthBoundLatitude,
+		"North":               projection.NorthBoundLatitude,
+		"DefaultDate":         defaultDate,
+		"FirstDate":           _firstDate,
+		"LastDate":            lastDate,
+		"Styles":              Styles,
+		"MaxScaleDenominator": MaxScaleDenominator,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write capabilities: %v", err)
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// ExceptionReport returns a service exception report, the code is one of the WMS exception codes or empty.
+// ex: InvalidCRS, LayerNotDefined
+func ExceptionReport(code, message string) []byte {
+	codeAttribute := ""
+	if code != "" {
+		codeAttribute = ` code="` + escapeXML(code) + `"`
+	}
+
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<ServiceExceptionReport version="` + Version + `" xmlns="http://www.opengis.net/ogc">
+  <ServiceException` + codeAttribute + `>` + escapeXML(message) + `</ServiceException>
+</ServiceExceptionReport>
+`)
+}
+
+// formatNumber formats a coordinate, degrees with 6 decimals and meters with 1.
+func formatNumber(number float64, degrees bool) string {
+	if degrees {
+		return strconv.FormatFloat(number, 'f', 6, 64)
+	}
+	return strconv.FormatFloat(number, 'f', 1, 64)
+}
+
+func escapeXML(text string) string {
+	var buffer bytes.Buffer
+	_ = xml.EscapeText(&buffer, []byte(text))
+	return buffer.String()
+}
@@ -0,0 +1,165 @@
+package wms
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"skogkursbachelor/server/internal/models"
+	"skogkursbachelor/server/internal/projection"
+	"skogkursbachelor/server/internal/utils"
+	"sort"
+)
+
+// _lineWidth is the width in pixels roads are drawn with
+const _lineWidth = 3.0
+
+// _featureInfoTolerance is how far, in pixels, a road may be from the queried pixel
+const _featureInfoTolerance = 5.0
+
+// View is the area of a map image, a bounding box in a CRS and the size of the image in pixels.
+// The bounding box is x, or longitude, first, whatever the axis order of the CRS is.
+type View struct {
+	CRS                    projection.CRS
+	MinX, MinY, MaxX, MaxY float64
+	Width, Height          int
+}
+
+// InternalBounds returns the EPSG:25833 bounding box that covers the view.
+// Returns projection.ErrOutsideNorway if the view does not overlap Norway.
+func (view View) InternalBounds() (float64, float64, float64, float64, error) {
+	return view.CRS.CheckedBoundsToInternal(view.MinX, view.MinY, view.MaxX, view.MaxY)
+}
+
+// pixels returns the road in pixel coordinates of the view, with the origin in the upper left corner.
+func (view View) pixels(road models.ForestRoad) [][2]float64 {
+	scaleX := float64(view.Width) / (view.MaxX - view.MinX)
+	scaleY := float64(view.Height) / (view.MaxY - view.MinY)
+
+	points := make([][2]float64, len(road.Geometry.Coordinates))
+	for i, coordinate := range road.Geometry.Coordinates {
+		x, y := view.CRS.FromInternal(coordinate[0], coordinate[1])
+		points[i] = [2]float64{(x - view.MinX) * scaleX, (view.MaxY - y) * scaleY}
+	}
+	return points
+}
+
+// Render draws the roads in the style. The background is transparent, or the background color if it is set.
+func Render(roads []models.ForestRoad, view View, style Style, background *color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, view.Width, view.Height))
+	if background != nil {
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = background.R, background.G, background.B, background.A
+		}
+	}
+
+	// Roads without data first, so roads with data are drawn on top
+	colors := make([]color.NRGBA, len(roads))
+	order := make([]int, len(roads))
+	for i, road := range roads {
+		colors[i] = style.Color(road.Properties)
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return colors[order[a]] == _noDataColor && colors[order[b]] != _noDataColor
+	})
+
+	for _, i := range order {
+		points := view.pixels(roads[i])
+		for j := 1; j < len(points); j++ {
+			drawSegment(img, points[j-1], points[j], colors[i])
+		}
+	}
+
+	return img
+}
+
+// FeatureInfo returns the roads within a few pixels of the pixel at i, j, the closest first, at most count roads.
+func FeatureInfo(roads []models.ForestRoad, view View, i, j int, count int) []models.ForestRoad {
+	pixel := [2]float64{float64(i) + 0.5, float64(j) + 0.5}
+
+	type hit struct {
+		road     models.ForestRoad
+		distance float64
+	}
+	var hits []hit
+	for _, road := range roads {
+		points := view.pixels(road)
+		distance := math.Inf(1)
+		for k := 1; k < len(points); k++ {
+			distance = math.Min(distance, utils.SegmentDistance(pixel, points[k-1], points[k]))
+		}
+		if distance <= _featureInfoTolerance {
+			hits = append(hits, hit{road, distance})
+		}
+	}
+
+	sort.SliceStable(hits, func(a, b int) bool { return hits[a].distance < hits[b].distance })
+	if len(hits) > count {
+		hits = hits[:count]
+	}
+
+	found := make([]models.ForestRoad, len(hits))
+	for k, h := range hits {
+		found[k] = h.road
+	}
+	return found
+}
+
+// drawSegment draws a line segment with round ends, by filling every pixel within half the line width of it.
+func drawSegment(img *image.NRGBA, a, b [2]float64, c color.NRGBA) {
+	a, b, ok := clipSegment(a, b, img.Bounds(), _lineWidth)
+	if !ok {
+		return
+	}
+
+	radius := _lineWidth / 2
+	bounds := img.Bounds()
+	minX := max(int(math.Floor(math.Min(a[0], b[0])-radius)), bounds.Min.X)
+	maxX := min(int(math.Ceil(math.Max(a[0], b[0])+radius)), bounds.Max.X-1)
+	minY := max(int(math.Floor(math.Min(a[1], b[1])-radius)), bounds.Min.Y)
+	maxY := min(int(math.Ceil(math.Max(a[1], b[1])+radius)), bounds.Max.Y-1)
+
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			if utils.SegmentDistance([2]float64{float64(x) + 0.5, float64(y) + 0.5}, a, b) <= radius {
+				img.SetNRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+// clipSegment clips the segment to the rectangle grown by the margin, with the Liang-Barsky algorithm.
+// Returns false if no part of the segment is inside.
+func clipSegment(a, b [2]float64, rect image.Rectangle, margin float64) ([2]float64, [2]float64, bool) {
+	minX, minY := float64(rect.Min.X)-margin, float64(rect.Min.Y)-margin
+	maxX, maxY := float64(rect.Max.X)+margin, float64(rect.Max.Y)+margin
+
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	t0, t1 := 0.0, 1.0
+	for _, edge := range [][2]float64{
+		{-dx, a[0] - minX},
+		{dx, maxX - a[0]},
+		{-dy, a[1] - minY},
+		{dy, maxY - a[1]},
+	} {
+		p, q := edge[0], edge[1]
+		if p == 0 {
+			if q < 0 {
+				return a, b, false
+			}
+			continue
+		}
+
+		t := q / p
+		if p < 0 {
+			t0 = math.Max(t0, t)
+		} else {
+			t1 = math.Min(t1, t)
+		}
+		if t0 > t1 {
+			return a, b, false
+		}
+	}
+
+	return [2]float64{a[0] + t0*dx, a[1] + t0*dy}, [2]float64{a[0] + t1*dx, a[1] + t1*dy}, true
+}
@@ -0,0 +1,170 @@
+package wms
+
+import (
+	"image/color"
+	"math"
+	"skogkursbachelor/server/internal/models"
+	"skogkursbachelor/server/internal/services/trafficability"
+)
+
+// Style colors the roads of the layer by one of their enriched properties.
+type Style struct {
+	Name     string
+	Title    string
+	Abstract string
+	// color returns the color of a road, or false when the property is null
+	color func(properties models.ForestRoadProperties) (color.NRGBA, bool)
+}
+
+// _noDataColor is the color of roads without a value for the style
+var _noDataColor = color.NRGBA{R: 140, G: 140, B: 140, A: 255}
+
+// colorStop is a value and the color at it, colors between stops are interpolated.
+type colorStop struct {
+	value float64
+	color color.NRGBA
+}
+
+// _frostDepthRamp goes from light to dark blue with the frost depth, in cm
+var _frostDepthRamp = []colorStop{
+	{0, color.NRGBA{R: 255, G: 237, B: 160, A: 255}},
+	{10, color.NRGBA{R: 158, G: 202, B: 225, A: 255}},
+	{30, color.NRGBA{R: 66, G: 146, B: 198, A: 255}},
+	{60, color.NRGBA{R: 8, G: 69, B: 148, A: 255}},
+	{100, color.NRGBA{R: 8, G: 29, B: 88, A: 255}},
+}
+
+// _saturationRamp goes from dry brown to wet blue with the water saturation, in percent
+var _saturationRamp = []colorStop{
+	{0, color.NRGBA{R: 166, G: 97, B: 26, A: 255}},
+	{50, color.NRGBA{R: 223, G: 194, B: 125, A: 255}},
+	{75, color.NRGBA{R: 128, G: 205, B: 193, A: 255}},
+	{100, color.NRGBA{R: 1, G: 102, B: 94, A: 255}},
+}
+
+// _depositColors color superficial deposits by their kind, from the first code in the range
+var _depositColors = []struct {
+	firstCode int
+	color     color.NRGBA
+}{
+	{10, color.NRGBA{R: 120, G: 180, B: 60, A: 255}},   // moraine
+	{20, color.NRGBA{R: 240, G: 160, B: 40, A: 255}},   // glaciofluvial
+	{30, color.NRGBA{R: 120, G: 200, B: 230, A: 255}},  // lacustrine
+	{40, color.NRGBA{R: 60, G: 110, B: 200, A: 255}},   // marine
+	{50, color.NRGBA{R: 250, G: 220, B: 60, A: 255}},   // fluvial
+	{60, color.NRGBA{R: 250, G: 240, B: 160, A: 255}},  // aeolian
+	{70, color.NRGBA{R: 200, G: 120, B: 170, A: 255}},  // weathering
+	{80, color.NRGBA{R: 190, G: 60, B: 60, A: 255}},    // slope
+	{90, color.NRGBA{R: 130, G: 90, B: 50, A: 255}},    // peat
+	{100, color.NRGBA{R: 240, G: 180, B: 200, A: 255}}, // thin or mixed cover over bedrock
+	{110, color.NRGBA{R: 230, G: 110, B: 170, A: 255}}, // bedrock
+	{120, color.NRGBA{R: 90, G: 90, B: 90, A: 255}},    // fill
+	{130, color.NRGBA{R: 230, G: 110, B: 170, A: 255}}, // bedrock
+	{140, color.NRGBA{R: 240, G: 180, B: 200, A: 255}}, // thin cover over bedrock
+	{150, color.NRGBA{R: 60, G: 110, B: 200, A: 255}},  // marine, maringeologi
+	{300, color.NRGBA{R: 190, G: 60, B: 60, A: 255}},   // slope
+}
+
+// _trafficabilityColors color the trafficability classes
+var _trafficabilityColors = map[string]color.NRGBA{
+	trafficability.ClassGreen:  {R: 26, G: 150, B: 65, A: 255},
+	trafficability.ClassYellow: {R: 250, G: 200, B: 30, A: 255},
+	trafficability.ClassRed:    {R: 215, G: 25, B: 28, A: 255},
+}
+
+// Styles are the styles of the layer, the first is the default
+var Styles = []Style{
+	{
+		Name:     "teledybde",
+		Title:    "Teledybde",
+		Abstract: "Frost depth in cm, from light yellow at 0 to dark blue at 100",
+		color: func(properties models.ForestRoadProperties) (color.NRGBA, bool) {
+			return rampColor(_frostDepthRamp, properties.Teledybde)
+		},
+	},
+	{
+		Name:     "vannmetning",
+		Title:    "Vannmetning",
+		Abstract: "Water saturation in percent, from brown at 0 to dark green at 100",
+		color: func(properties models.ForestRoadProperties) (color.NRGBA, bool) {
+			return rampColor(_saturationRamp, properties.Vannmetning)
+		},
+	},
+	{
+		Name:     "losmasse",
+		Title:    "Løsmasse",
+		Abstract: "The superficial deposit the longest part of the road is on, colored by kind",
+		color: func(properties models.ForestRoadProperties) (color.NRGBA, bool) {
+			segment, ok := properties.DominantDeposit()
+			if !ok {
+				return color.NRGBA{}, false
+			}
+
+			c, found := _noDataColor, false
+			for _, depositColor := range _depositColors {
+				if segment.Kode >= depositColor.firstCode {
+					c, found = depositColor.color, true
+				}
+			}
+			return c, found
+		},
+	},
+	{
+		Name:     "framkommelighet",
+		Title:    "Framkommelighet",
+		Abstract: "Trafficability class, green, yellow or red",
+		color: func(properties models.ForestRoadProperties) (color.NRGBA, bool) {
+			c, ok := _trafficabilityColors[properties.Framkommelighetsklasse]
+			return c, ok
+		},
+	},
+}
+
+// GetStyle returns the style with the name, an empty name is the default style.
+func GetStyle(name string) (Style, bool) {
+	if name == "" || name == "default" {
+		return Styles[0], true
+	}
+	for _, style := range Styles {
+		if style.Name == name {
+			return style, true
+		}
+	}
+	return Style{}, false
+}
+
+// Color returns the color of the road in the style.
+func (style Style) Color(properties models.ForestRoadProperties) color.NRGBA {
+	c, ok := style.color(properties)
+	if !ok {
+		return _noDataColor
+	}
+	return c
+}
+
+// rampColor interpolates the color of the value between the stops of the ramp.
+func rampColor(ramp []colorStop, value *float64) (color.NRGBA, bool) {
+	if value == nil {
+		return color.NRGBA{}, false
+	}
+
+	v := *value
+	if v <= ramp[0].value {
+		return ramp[0].color, true
+	}
+	for i := 1; i < len(ramp); i++ {
+		if v > ramp[i].value {
+			continue
+		}
+
+		t := (v - ramp[i-1].value) / (ramp[i].value - ramp[i-1].value)
+		from, to := ramp[i-1].color, ramp[i].color
+		return color.NRGBA{
+			R: uint8(math.Round(float64(from.R) + t*(float64(to.R)-float64(from.R)))),
+			G: uint8(math.Round(float64(from.G) + t*(float64(to.G)-float64(from.G)))),
+			B: uint8(math.Round(float64(from.B) + t*(float64(to.B)-float64(from.B)))),
+			A: 255,
+		}, true
+	}
+	return ramp[len(ramp)-1].color, true
+}
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// GetEnvInt gets an integer from the environment variable, or uses the default value if it is not set or invalid.
+func GetEnvInt(name string, defaultValue int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Warn().Msgf("$%s is not an integer: %s. Default: %d", name, value, defaultValue)
+		return defaultValue
+	}
+
+	return parsed
+}
+
+// GetEnvDuration gets a duration, e.g. 15m, from the environment variable,
+// or uses the default value if it is not set or invalid.
+func GetEnvDuration(name string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Warn().Msgf("$%s is not a duration: %s. Default: %s", name, value, defaultValue)
+		return defaultValue
+	}
+
+	return parsed
+}
@@ -18,3 +18,21 @@ func RoundToNearest500(n float64) int {
 	base := (int(math.Round(n)) / 1000) * 1000
 	return base + 500
 }
+
+// Distance returns the euclidean distance between two projected coordinates.
+func Distance(a, b []float64) float64 {
+	return math.Hypot(b[0]-a[0], b[1]-a[1])
+}
+
+// SegmentDistance returns the euclidean distance from the point to the line segment from a to b.
+func SegmentDistance(point, a, b [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	lengthSquared := dx*dx + dy*dy
+	if lengthSquared == 0 {
+		return math.Hypot(point[0]-a[0], point[1]-a[1])
+	}
+
+	t := ((point[0]-a[0])*dx + (point[1]-a[1])*dy) / lengthSquared
+	t = math.Max(0, math.Min(1, t))
+	return math.Hypot(point[0]-(a[0]+t*dx), point[1]-(a[1]+t*dy))
+}